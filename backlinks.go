@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// linkRef is a line in a zettel containing a link of interest.
+type linkRef struct {
+	Source string // the zettel ID containing the link
+	Line   int
+	Text   string
+}
+
+// findLinkRefs returns the lines in the given zettels with links to targets
+// matched by the given function. The read function retrieves the content of
+// a file in the zettel dir.
+func findLinkRefs(sources []string, read func(name string) (string, bool, error), match func(target string) bool) ([]linkRef, error) {
+	ret := []linkRef{}
+	for _, s := range sources {
+		content, exists, err := read(s + ".md")
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		for i, line := range strings.Split(content, "\n") {
+			for _, m := range linkRegex.FindAllStringSubmatch(line, -1) {
+				if match(m[1]) {
+					ret = append(ret, linkRef{Source: s, Line: i + 1, Text: strings.TrimSpace(line)})
+					break
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// readZettelFile reads a file in the zettel dir, reporting whether it exists.
+func readZettelFile(name string) (string, bool, error) {
+	buf, err := os.ReadFile(path.Join(zetDir, name))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("unable to read %q: %w", name, err)
+	}
+	return string(buf), true, nil
+}
+
+// backlinks returns every line in the kasten linking to the given zettel or
+// branch, including links to the branch a zettel is a member of.
+func backlinks(id ZettelID) ([]linkRef, error) {
+	ix, err := getIndex()
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]bool{id.String(): true}
+	if branch, err := id.Branch(); err == nil {
+		targets[branch.String()] = true
+	}
+	return findLinkRefs(ix.backlinksOf(id), readZettelFile, func(target string) bool {
+		return targets[target]
+	})
+}
+
+var BacklinksCommand = cmdtree.Cmd{
+	CommandName: "backlinks",
+	SubCommands: []*cmdtree.Cmd{
+		{
+			CommandName: "path",
+			Exec: func(args []string) error {
+				id, err := getIdFromPathOnArgs(&args)
+				if err != nil {
+					return fmt.Errorf("error while getting id from args in backlinks path command: %w", err)
+				}
+				zid, err := ParseZettelID(id)
+				if err != nil {
+					return fmt.Errorf("invalid zettel id: %w", err)
+				}
+				refs, err := backlinks(zid)
+				if err != nil {
+					return fmt.Errorf("failed to find backlinks: %w", err)
+				}
+				// NOTE: in the file:line:text format understood by vim's
+				// quickfix list and most other editors
+				for _, r := range refs {
+					fmt.Printf("%s:%d:%s\n", path.Join(zetDir, r.Source+".md"), r.Line, r.Text)
+				}
+				return nil
+			},
+		},
+	},
+	Exec: func(args []string) error {
+		arg, err := cmdtree.SliceShift(&args)
+		if err != nil {
+			return fmt.Errorf("usage: zet2 backlinks <id|path>")
+		}
+		id, err := ParseZettelID(idFromArg(arg))
+		if err != nil {
+			return fmt.Errorf("invalid zettel id: %w", err)
+		}
+		refs, err := backlinks(id)
+		if err != nil {
+			return fmt.Errorf("failed to find backlinks: %w", err)
+		}
+		for _, r := range refs {
+			fmt.Printf("%s: %s\n", r.Source, r.Text)
+		}
+		return nil
+	},
+}
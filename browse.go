@@ -0,0 +1,593 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/morngrar/zet2/cmdtree"
+	"golang.org/x/term"
+)
+
+// The browser is a full screen terminal UI with the folgezettel tree to the
+// left and a preview of the selected zettel to the right. It talks to the
+// terminal directly with ANSI escape codes, in raw mode, to keep the
+// dependencies down to x/term.
+
+const (
+	ansiAltScreen    = "\x1b[?1049h\x1b[?25l"
+	ansiNormalScreen = "\x1b[?25h\x1b[?1049l"
+	ansiHome         = "\x1b[H"
+	ansiClearLine    = "\x1b[K"
+	ansiClearBelow   = "\x1b[J"
+	ansiReset        = "\x1b[0m"
+	ansiBold         = "\x1b[1m"
+	ansiDim          = "\x1b[2m"
+	ansiReverse      = "\x1b[7m"
+	ansiLink         = "\x1b[4;36m"
+	ansiMatch        = "\x1b[1;31m"
+)
+
+const browseHelp = "j/k move  n/p next/prev  h parent  b branch  tab/enter link  B backlinks  o open  q quit"
+
+type browser struct {
+	roots    []*treeNode
+	rows     []treeRow
+	selected int
+	scroll   int
+
+	links         []string // links of the selected zettel, as last rendered
+	link          int      // highlighted link, or -1
+	previewScroll int
+
+	// when set, the left pane shows this list instead of the tree
+	list *browserList
+
+	message string
+
+	// the terminal state to restore when suspending or quitting
+	termState *term.State
+}
+
+type browserList struct {
+	title    string
+	ids      []string
+	selected int
+	scroll   int
+}
+
+func newBrowser() (*browser, error) {
+	b := &browser{link: -1}
+	return b, b.reload()
+}
+
+// reload rebuilds the tree from the index, keeping the selection if possible.
+func (b *browser) reload() error {
+	current := ""
+	if b.selected < len(b.rows) {
+		current = b.rows[b.selected].node.name()
+	}
+	ids, err := getAllIds()
+	if err != nil {
+		return err
+	}
+	b.roots = buildTree(ids)
+	b.rows = flattenTree(b.roots, -1)
+	b.selected = 0
+	if current != "" {
+		b.selectId(current)
+	}
+	b.selectionChanged()
+	return nil
+}
+
+func (b *browser) current() *treeNode {
+	if len(b.rows) == 0 {
+		return nil
+	}
+	return b.rows[b.selected].node
+}
+
+// selectId moves the selection to the given zettel, returning false if it
+// isn't in the tree.
+func (b *browser) selectId(id string) bool {
+	for i, r := range b.rows {
+		if r.node.name() == id {
+			b.selected = i
+			b.selectionChanged()
+			return true
+		}
+	}
+	return false
+}
+
+func (b *browser) move(delta int) {
+	if b.list != nil {
+		b.list.selected = max(0, min(len(b.list.ids)-1, b.list.selected+delta))
+		return
+	}
+	if len(b.rows) == 0 {
+		return
+	}
+	b.selected = max(0, min(len(b.rows)-1, b.selected+delta))
+	b.selectionChanged()
+}
+
+// selectionChanged resets the state of the preview. The links are found when
+// the preview is rendered, so that they are exactly the ones highlighted.
+func (b *browser) selectionChanged() {
+	b.link = -1
+	b.previewScroll = 0
+	b.links = nil
+}
+
+func zettelPath(id string) string {
+	return path.Join(zetDir, id+".md")
+}
+
+// goTo selects the given zettel or, for a branch, its first member.
+func (b *browser) goTo(id string) {
+	if !fileExists(zettelPath(id)) {
+		first, err := getFirstSeqInBranch(id)
+		if err != nil {
+			b.message = fmt.Sprintf("%q does not exist", id)
+			return
+		}
+		id = first
+	}
+	if !b.selectId(id) {
+		b.message = fmt.Sprintf("%q is not in the tree", id)
+	}
+}
+
+// currentZettel returns the selected zettel, or an error message if a prefix is
+// selected.
+func (b *browser) currentZettel() (ZettelID, bool) {
+	n := b.current()
+	if n == nil || n.isPrefix() {
+		b.message = "not a zettel"
+		return ZettelID{}, false
+	}
+	return n.ID, true
+}
+
+func (b *browser) showBacklinks() {
+	id, ok := b.currentZettel()
+	if !ok {
+		return
+	}
+	ix, err := getIndex()
+	if err != nil {
+		b.message = err.Error()
+		return
+	}
+	sources := ix.backlinksOf(id)
+	if len(sources) == 0 {
+		b.message = fmt.Sprintf("nothing links to %s", id)
+		return
+	}
+	b.list = &browserList{title: "backlinks to " + id.String(), ids: sources}
+}
+
+// handleKey acts on a key press, returning true when the browser should quit.
+func (b *browser) handleKey(key string, fd int) (bool, error) {
+	b.message = ""
+	switch key {
+	case "q", "\x03":
+		return true, nil
+	case "\x1b":
+		if b.list != nil {
+			b.list = nil
+		} else {
+			b.link = -1
+		}
+	case "j", "\x1b[B", "\x1bOB":
+		b.move(1)
+	case "k", "\x1b[A", "\x1bOA":
+		b.move(-1)
+	case "g", "\x1b[H":
+		b.move(-len(b.rows))
+	case "G", "\x1b[F":
+		b.move(len(b.rows))
+	case "\x04": // ctrl-d
+		b.previewScroll += 10
+	case "\x15": // ctrl-u
+		b.previewScroll = max(0, b.previewScroll-10)
+	case "n":
+		if id, ok := b.currentZettel(); ok {
+			next, _, err := determineNextZet(id.String())
+			if err != nil {
+				b.message = err.Error()
+				break
+			}
+			b.goTo(next)
+		}
+	case "p":
+		if id, ok := b.currentZettel(); ok {
+			prev, _, err := determinePrevZet(id.String())
+			if err != nil {
+				b.message = err.Error()
+				break
+			}
+			b.goTo(prev)
+		}
+	case "h", "\x1b[D", "\x1bOD":
+		if id, ok := b.currentZettel(); ok {
+			parent, err := id.Parent()
+			if err != nil {
+				b.selectId(id.Prefix)
+				break
+			}
+			b.goTo(parent.String())
+		}
+	case "b", "l", "\x1b[C", "\x1bOC":
+		if id, ok := b.currentZettel(); ok {
+			branches, err := branchesOf(id)
+			if err != nil || len(branches) == 0 {
+				b.message = fmt.Sprintf("%s has no branches", id)
+				break
+			}
+			b.goTo(branches[0].String())
+		}
+	case "\t":
+		if len(b.links) > 0 {
+			b.link = (b.link + 1) % len(b.links)
+		}
+	case "\x1b[Z": // shift-tab
+		if len(b.links) > 0 {
+			b.link = (b.link - 1 + len(b.links)) % len(b.links)
+		}
+	case "\r", "f":
+		if b.list != nil {
+			id := b.list.ids[b.list.selected]
+			b.list = nil
+			b.goTo(id)
+			break
+		}
+		if len(b.links) == 0 {
+			b.message = "no links to follow"
+			break
+		}
+		b.goTo(b.links[max(0, b.link)])
+	case "B":
+		b.showBacklinks()
+	case "o", "e":
+		id, ok := b.currentZettel()
+		if !ok {
+			break
+		}
+		err := b.suspend(fd, func() error {
+			return openInEditor(zettelPath(id.String()), false)
+		})
+		if err != nil {
+			return true, err
+		}
+		err = b.reload()
+		if err != nil {
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+// suspend restores the terminal while running f, e.g. an editor.
+func (b *browser) suspend(fd int, f func() error) error {
+	fmt.Print(ansiNormalScreen)
+	err := term.Restore(fd, b.termState)
+	if err != nil {
+		return err
+	}
+	runErr := f()
+	_, err = term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	fmt.Print(ansiAltScreen)
+	if runErr != nil {
+		b.message = runErr.Error()
+	}
+	return nil
+}
+
+// draw renders the whole screen.
+func (b *browser) draw(width, height int) {
+	if width < 20 || height < 4 {
+		fmt.Print(ansiHome + "terminal too small" + ansiClearBelow)
+		return
+	}
+	bodyHeight := height - 2
+	leftWidth := min(40, width/3)
+	rightWidth := width - leftWidth - 3
+
+	left := b.leftPane(leftWidth, bodyHeight)
+	right := b.previewPane(rightWidth, bodyHeight)
+
+	var sb strings.Builder
+	sb.WriteString(ansiHome)
+	header := fmt.Sprintf(" zet2 browse - %s (%s)", kastenName, zetDir)
+	sb.WriteString(ansiReverse + padRight(truncateRunes(header, width), width) + ansiReset + "\r\n")
+	for i := range bodyHeight {
+		sb.WriteString(left[i])
+		sb.WriteString(ansiDim + " | " + ansiReset)
+		sb.WriteString(right[i])
+		sb.WriteString(ansiReset + ansiClearLine + "\r\n")
+	}
+	footer := b.message
+	if footer == "" {
+		footer = browseHelp
+	}
+	sb.WriteString(ansiDim + truncateRunes(footer, width) + ansiReset + ansiClearLine)
+	fmt.Print(sb.String())
+}
+
+func (b *browser) leftPane(width, height int) []string {
+	lines := make([]string, height)
+	var labels []string
+	selected := 0
+	scroll := &b.scroll
+	if b.list != nil {
+		height-- // NOTE: room for the title
+		labels = b.list.ids
+		selected = b.list.selected
+		scroll = &b.list.scroll
+	} else {
+		for _, r := range b.rows {
+			labels = append(labels, r.lead+r.node.name())
+		}
+		selected = b.selected
+	}
+
+	if selected < *scroll {
+		*scroll = selected
+	}
+	if selected >= *scroll+height {
+		*scroll = selected - height + 1
+	}
+
+	out := lines
+	if b.list != nil {
+		lines[0] = ansiBold + padRight(truncateRunes(b.list.title, width), width) + ansiReset
+		out = lines[1:]
+	}
+	for i := range out {
+		idx := *scroll + i
+		if idx >= len(labels) {
+			out[i] = strings.Repeat(" ", width)
+			continue
+		}
+		label := padRight(truncateRunes(labels[idx], width), width)
+		if idx == selected {
+			label = ansiReverse + label + ansiReset
+		}
+		out[i] = label
+	}
+	return lines
+}
+
+func (b *browser) previewPane(width, height int) []string {
+	lines := make([]string, height)
+	n := b.current()
+
+	var rendered []string
+	b.links = nil
+	switch {
+	case n == nil:
+		rendered = []string{"the kasten is empty"}
+	case n.isPrefix():
+		rendered = []string{ansiBold + n.Prefix + ansiReset, "", fmt.Sprintf("%d top level zettels", len(n.Children))}
+	default:
+		content, err := os.ReadFile(zettelPath(n.name()))
+		if err != nil {
+			rendered = []string{err.Error()}
+			break
+		}
+		rendered, b.links = renderPreview(n.name(), string(content), width, b.link)
+	}
+
+	b.previewScroll = max(0, min(b.previewScroll, len(rendered)-1))
+	for i := range lines {
+		if b.previewScroll+i < len(rendered) {
+			lines[i] = rendered[b.previewScroll+i]
+		}
+	}
+	return lines
+}
+
+// renderPreview renders the markdown of a zettel for the terminal, wrapped to
+// the given width, and returns the links in it in the order shown. The link
+// with the given index is highlighted. Links in the frontmatter and in code
+// aren't shown as links, and so aren't returned.
+func renderPreview(id, content string, width, activeLink int) ([]string, []string) {
+	front, body := splitFrontmatter(content)
+	meta := parseFrontmatter(front)
+
+	header := id
+	if title := meta["title"]; title != "" {
+		header += "  " + title
+	}
+	lines := []string{ansiBold + truncateRunes(header, width) + ansiReset}
+	if date := meta["date"]; date != "" {
+		lines = append(lines, ansiDim+truncateRunes(date, width)+ansiReset)
+	}
+	lines = append(lines, "")
+
+	links := []string{}
+	inCode := false
+	for line := range strings.SplitSeq(strings.Trim(body, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inCode = !inCode
+			continue
+		}
+
+		style := ""
+		switch {
+		case inCode:
+			style = ansiDim
+		case strings.HasPrefix(trimmed, "#"):
+			style = ansiBold
+			line = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			line = indent + "• " + trimmed[2:]
+		}
+
+		// NOTE: links are found before wrapping, so that one cut by the
+		// wrapping is still a single link, highlighted on both lines
+		runes := []rune(line)
+		spans := []linkSpan{}
+		if !inCode {
+			for _, m := range linkRegex.FindAllStringSubmatchIndex(line, -1) {
+				spans = append(spans, linkSpan{
+					start: utf8.RuneCountInString(line[:m[0]]),
+					end:   utf8.RuneCountInString(line[:m[1]]),
+					index: len(links),
+				})
+				links = append(links, line[m[2]:m[3]])
+			}
+		}
+
+		for _, r := range wrapRanges(runes, width) {
+			var sb strings.Builder
+			sb.WriteString(style)
+			pos := r[0]
+			for _, l := range spans {
+				start, end := max(l.start, r[0]), min(l.end, r[1])
+				if start >= end {
+					continue
+				}
+				linkStyle := ansiLink
+				if l.index == activeLink {
+					linkStyle = ansiReverse
+				}
+				sb.WriteString(string(runes[pos:start]))
+				sb.WriteString(linkStyle + string(runes[start:end]) + ansiReset + style)
+				pos = end
+			}
+			sb.WriteString(string(runes[pos:r[1]]))
+			lines = append(lines, sb.String()+ansiReset)
+		}
+	}
+	return lines, links
+}
+
+// linkSpan is the position of a link in a line, in runes.
+type linkSpan struct {
+	start, end int
+	index      int // the index of the link in the zettel
+}
+
+// wrapRanges wraps a line to the given width, breaking at spaces when
+// possible, and returns the start and end of every wrapped line, in runes.
+func wrapRanges(runes []rune, width int) [][2]int {
+	ret := [][2]int{}
+	start := 0
+	for len(runes)-start > width {
+		cut := start + width
+		for i := start + width; i > start; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		ret = append(ret, [2]int{start, cut})
+		start = cut
+		for start < len(runes) && runes[start] == ' ' {
+			start++
+		}
+	}
+	return append(ret, [2]int{start, len(runes)})
+}
+
+// truncateRunes shortens a string to at most the given number of runes,
+// marking the cut with an ellipsis.
+func truncateRunes(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width < 4 {
+		return string([]rune(s)[:width])
+	}
+	return string([]rune(s)[:width-3]) + "..."
+}
+
+func padRight(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-n)
+}
+
+var BrowseCommand = cmdtree.Cmd{
+	CommandName: "browse",
+	Exec: func(args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("usage: zet2 browse [id]")
+		}
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+			return fmt.Errorf("browse needs a terminal")
+		}
+
+		b, err := newBrowser()
+		if err != nil {
+			return err
+		}
+		if len(args) == 1 {
+			b.goTo(idFromArg(args[0]))
+		}
+
+		b.termState, err = term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("unable to set up terminal: %w", err)
+		}
+		defer term.Restore(fd, b.termState)
+		fmt.Print(ansiAltScreen)
+		defer fmt.Print(ansiNormalScreen)
+
+		resized := make(chan os.Signal, 1)
+		notifyResize(resized)
+		defer signal.Stop(resized)
+
+		// NOTE: a key is only read when asked for, so that nothing is read
+		// from under the editor while the browser is suspended
+		type keyRead struct {
+			key string
+			err error
+		}
+		keys := make(chan keyRead)
+		buf := make([]byte, 32)
+		readKey := func() {
+			go func() {
+				n, err := os.Stdin.Read(buf)
+				keys <- keyRead{string(buf[:n]), err}
+			}()
+		}
+
+		readKey()
+		for {
+			width, height, err := term.GetSize(int(os.Stdout.Fd()))
+			if err != nil {
+				return fmt.Errorf("unable to get terminal size: %w", err)
+			}
+			b.draw(width, height)
+
+			select {
+			case <-resized:
+			case k := <-keys:
+				if k.err != nil {
+					return fmt.Errorf("unable to read input: %w", k.err)
+				}
+				quit, err := b.handleKey(k.key, fd)
+				if err != nil || quit {
+					return err
+				}
+				readKey()
+			}
+		}
+	},
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyResize does nothing where there is no SIGWINCH, so the new size is
+// only picked up on the next key press.
+func notifyResize(c chan<- os.Signal) {}
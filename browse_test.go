@@ -0,0 +1,63 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var ansiRegex = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func TestRenderPreviewLinks(t *testing.T) {
+	content := "---\nzettel: tmp.1\nsee: [[front.1]]\n---\n\nfirst [[a.1]]\n\n```\n[[code.1]]\n```\n\nxxxxxxxxxx[[b.12]] and [[c.3]]\n"
+	lines, links := renderPreview("tmp.1", content, 12, 1)
+
+	if want := []string{"a.1", "b.12", "c.3"}; !slices.Equal(links, want) {
+		t.Fatalf("links = %q, want %q", links, want)
+	}
+
+	// NOTE: the highlighted link is cut by the wrapping, and highlighted on
+	// both lines
+	highlighted := ""
+	for _, l := range lines {
+		for _, part := range strings.Split(l, ansiReverse)[1:] {
+			highlighted += part[:strings.Index(part, ansiReset)]
+		}
+	}
+	if highlighted != "[[b.12]]" {
+		t.Errorf("highlighted %q, want [[b.12]]", highlighted)
+	}
+
+	plain := []string{}
+	for _, l := range lines {
+		plain = append(plain, ansiRegex.ReplaceAllString(l, ""))
+	}
+	if want := "\nxxxxxxxxxx[[\nb.12]] and\n[[c.3]]"; !strings.HasSuffix(strings.Join(plain, "\n"), want) {
+		t.Errorf("wrapped lines %q, want them to end with %q", plain, want)
+	}
+}
+
+func TestWrapRanges(t *testing.T) {
+	tests := []struct {
+		line  string
+		width int
+		want  []string
+	}{
+		{"", 5, []string{""}},
+		{"short", 5, []string{"short"}},
+		{"one two three", 7, []string{"one two", "three"}},
+		{"one   two", 4, []string{"one ", "two"}},
+		{"abcdefgh", 3, []string{"abc", "def", "gh"}},
+	}
+	for _, tt := range tests {
+		runes := []rune(tt.line)
+		got := []string{}
+		for _, r := range wrapRanges(runes, tt.width) {
+			got = append(got, string(runes[r[0]:r[1]]))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("wrapRanges(%q, %d) = %q, want %q", tt.line, tt.width, got, tt.want)
+		}
+	}
+}
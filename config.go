@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// The configuration file is a plain text file of 'key = value' lines, where
+// blank lines and lines starting with '#' are ignored. It is looked up in the
+// following order:
+//
+//   - the path given by the global --config option
+//   - the path in the ZET2_CONFIG environment variable
+//   - $XDG_CONFIG_HOME/zet2/config, falling back to ~/.config/zet2/config
+//
+// A missing configuration file is not an error, all keys have defaults.
+
+type configKey struct {
+	name        string
+	description string
+	defaultVal  string
+}
+
+var configKeys = []configKey{
+	// NOTE: temporary prod-dir until 1.0, then the trailing 2 will be dropped
+	// in command and dir
+	{"dir", "directory containing the zettels, unless a named kasten is used", "~/zettel2"},
+	{"kasten", "name of the kasten to use when --kasten isn't given", ""},
+	{"default_prefix", "prefix used when creating a zettel without giving one", "tmp"},
+	{"editor", "editor command, where {{path}} and {{line}} are substituted (defaults to $EDITOR)", ""},
+	{"timestamp_format", "Go time layout for the date of new zettels", "Mon 2006-01-02 15:04:05 MST"},
+	{"reserved_prefixes", "comma separated prefixes to disallow, in addition to the subcommands", ""},
+	{"template_dir", "directory of templates for new zettels (defaults to templates/ next to the config file)", ""},
+	{"git_commit", "commit every operation to the git repository holding the kasten", "false"},
+	{"git_remote", "git remote that the sync command pulls from and pushes to", "origin"},
+}
+
+// the config in effect for this invocation
+var currentConfig = &configFile{}
+
+type configFile struct {
+	path  string
+	lines []string
+}
+
+// configPath determines where the configuration file lives, given the value
+// of the --config option, which may be empty.
+func configPath(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if p := os.Getenv("ZET2_CONFIG"); p != "" {
+		return p, nil
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to retrieve user's home directory: %w", err)
+		}
+		configHome = path.Join(homeDir, ".config")
+	}
+	return path.Join(configHome, "zet2", "config"), nil
+}
+
+func loadConfig(filePath string) (*configFile, error) {
+	cfg := &configFile{path: filePath}
+	buf, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file %q: %w", filePath, err)
+	}
+	content := strings.TrimSuffix(string(buf), "\n")
+	if content != "" {
+		cfg.lines = strings.Split(content, "\n")
+	}
+	for i, line := range cfg.lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if _, _, found := strings.Cut(trimmed, "="); !found {
+			return nil, fmt.Errorf("%s:%d: expected 'key = value', got %q", filePath, i+1, line)
+		}
+	}
+	return cfg, nil
+}
+
+func (c *configFile) save() error {
+	err := os.MkdirAll(path.Dir(c.path), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to create config dir: %w", err)
+	}
+	content := strings.Join(c.lines, "\n") + "\n"
+	return writeFileAtomic(c.path, []byte(content))
+}
+
+// find returns the line number of the given key, or -1 if it is not set.
+func (c *configFile) find(key string) int {
+	for i, line := range c.lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		k, _, found := strings.Cut(trimmed, "=")
+		if found && strings.TrimSpace(k) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// get returns the value of a key, and whether it was set in the file at all.
+func (c *configFile) get(key string) (string, bool) {
+	i := c.find(key)
+	if i == -1 {
+		return "", false
+	}
+	_, value, _ := strings.Cut(c.lines[i], "=")
+	return strings.TrimSpace(value), true
+}
+
+// value returns the value of a key, or its default if it isn't set.
+func (c *configFile) value(key string) string {
+	if v, ok := c.get(key); ok {
+		return v
+	}
+	for _, k := range configKeys {
+		if k.name == key {
+			return k.defaultVal
+		}
+	}
+	return ""
+}
+
+func (c *configFile) set(key, value string) {
+	line := fmt.Sprintf("%s = %s", key, value)
+	if i := c.find(key); i != -1 {
+		c.lines[i] = line
+		return
+	}
+	c.lines = append(c.lines, line)
+}
+
+func (c *configFile) unset(key string) bool {
+	i := c.find(key)
+	if i == -1 {
+		return false
+	}
+	c.lines = append(c.lines[:i], c.lines[i+1:]...)
+	return true
+}
+
+// keys returns all keys set in the file, in lexical order.
+func (c *configFile) keys() []string {
+	ret := []string{}
+	for _, line := range c.lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		k, _, _ := strings.Cut(trimmed, "=")
+		ret = append(ret, strings.TrimSpace(k))
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func isKnownConfigKey(key string) bool {
+	if name, ok := strings.CutPrefix(key, kastenKeyPrefix); ok {
+		return validateKastenName(name) == nil
+	}
+	for _, k := range configKeys {
+		if k.name == key {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConfig sets up the global settings of the program from the config. The
+// kasten argument is the value of the --kasten option, and may be empty.
+func applyConfig(cfg *configFile, kasten string) error {
+	currentConfig = cfg
+
+	defaultPrefix = cfg.value("default_prefix")
+	timestampFormat = cfg.value("timestamp_format")
+	if e := cfg.value("editor"); e != "" {
+		editor = e
+	}
+
+	for p := range strings.SplitSeq(cfg.value("reserved_prefixes"), ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			reservedPrefixes = append(reservedPrefixes, p)
+		}
+	}
+
+	// NOTE: the kasten is resolved last, so that everything else is in effect
+	// for the commands that can run without one
+	if DEBUG {
+		if kasten != "" {
+			return fmt.Errorf("--kasten cannot be used with ZET2_DEBUG, which always uses %q", zetDir)
+		}
+		return nil
+	}
+	dir, err := resolveKasten(cfg, kasten)
+	if err != nil {
+		return err
+	}
+	dir, err = expandHome(dir)
+	if err != nil {
+		return fmt.Errorf("unable to determine zettel dir: %w", err)
+	}
+	zetDir = dir
+	return nil
+}
+
+// expandHome replaces a leading '~' in the path with the user's home
+// directory.
+func expandHome(p string) (string, error) {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve user's home directory: %w", err)
+	}
+	return path.Join(homeDir, p[1:]), nil
+}
+
+// extractGlobalOptions removes the global options from the start of the
+// argument list (right after the program name), returning the remaining
+// arguments and the values of the options found.
+func extractGlobalOptions(args []string) ([]string, map[string]string, error) {
+	options := map[string]string{}
+	if len(args) == 0 {
+		return args, options, nil
+	}
+	rest := args[1:]
+	for len(rest) > 0 {
+		name, value, hasValue := strings.Cut(rest[0], "=")
+		if !isGlobalOption(name) {
+			break
+		}
+		rest = rest[1:]
+		if !hasValue {
+			if len(rest) == 0 {
+				return nil, nil, fmt.Errorf("global option %s requires a value", name)
+			}
+			value = rest[0]
+			rest = rest[1:]
+		}
+		options[name] = value
+	}
+	return append([]string{args[0]}, rest...), options, nil
+}
+
+var globalOptions = []string{
+	"--config",
+	"--kasten",
+}
+
+func isGlobalOption(name string) bool {
+	for _, o := range globalOptions {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+var ConfigCommand = cmdtree.Cmd{
+	CommandName: "config",
+	SubCommands: []*cmdtree.Cmd{
+		{
+			CommandName: "get",
+			Exec: func(args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("usage: zet2 config get <key>")
+				}
+				key := args[0]
+				if v, ok := currentConfig.get(key); ok {
+					fmt.Println(v)
+					return nil
+				}
+				if !isKnownConfigKey(key) {
+					return fmt.Errorf("config key %q is not set", key)
+				}
+				fmt.Println(currentConfig.value(key))
+				return nil
+			},
+		},
+		{
+			CommandName: "set",
+			Exec: func(args []string) error {
+				if len(args) < 2 {
+					return fmt.Errorf("usage: zet2 config set <key> <value>")
+				}
+				key := args[0]
+				value := strings.Join(args[1:], " ")
+				if !isKnownConfigKey(key) {
+					return fmt.Errorf("unknown config key %q", key)
+				}
+				if _, exists := currentConfig.get(kastenKeyPrefix + value); key == "kasten" && value != defaultKastenName && !exists {
+					return fmt.Errorf("unknown kasten %q, add it with 'zet2 kasten add' first", value)
+				}
+				currentConfig.set(key, value)
+				return currentConfig.save()
+			},
+		},
+		{
+			CommandName: "unset",
+			Exec: func(args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("usage: zet2 config unset <key>")
+				}
+				if !currentConfig.unset(args[0]) {
+					return fmt.Errorf("config key %q is not set", args[0])
+				}
+				return currentConfig.save()
+			},
+		},
+		{
+			CommandName: "list",
+			Exec: func(args []string) error {
+				fmt.Printf("# %s\n", currentConfig.path)
+				for _, k := range configKeys {
+					v, ok := currentConfig.get(k.name)
+					if !ok {
+						v = k.defaultVal
+					}
+					fmt.Printf("%s = %s\n", k.name, v)
+				}
+				for _, k := range currentConfig.keys() {
+					if isKnownConfigKey(k) {
+						continue
+					}
+					v, _ := currentConfig.get(k)
+					fmt.Printf("%s = %s\n", k, v)
+				}
+				return nil
+			},
+		},
+	},
+	Exec: func(args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("unknown config subcommand %q", args[0])
+		}
+		for _, k := range configKeys {
+			fmt.Printf("%-18s %s\n", k.name, k.description)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// the number of unchanged lines shown around each change in a diff
+const diffContext = 2
+
+// diffOp is a single line of a line based diff, where kind is ' ' for lines
+// in both versions, '-' for removed lines and '+' for added lines.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines computes a minimal line diff between a and b, using the longest
+// common subsequence. Zettels are short, so the quadratic cost is fine.
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := []diffOp{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// appended to the last line of content lacking a final newline, so that it
+// differs from the same line with one, and is shown as in diff(1)
+const noNewlineMarker = "\n\\ No newline at end of file"
+
+// splitContentLines splits file content into lines, where nil content (a file
+// that doesn't exist) has no lines at all.
+func splitContentLines(content *string) []string {
+	if content == nil || *content == "" {
+		return nil
+	}
+	trimmed, hasNewline := strings.CutSuffix(*content, "\n")
+	lines := strings.Split(trimmed, "\n")
+	if !hasNewline {
+		lines[len(lines)-1] += noNewlineMarker
+	}
+	return lines
+}
+
+// writeUnifiedDiff writes a diff of the two versions of a file in unified
+// format, with hunk headers giving the line numbers in each version.
+func writeUnifiedDiff(w io.Writer, fromName, toName string, before, after *string) {
+	ops := diffLines(splitContentLines(before), splitContentLines(after))
+
+	if before == nil {
+		fromName = "/dev/null"
+	}
+	if after == nil {
+		toName = "/dev/null"
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", fromName, toName)
+
+	// NOTE: line numbers in each version at the start of every op
+	aLine, bLine := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	aLine[0], bLine[0] = 1, 1
+	for k, op := range ops {
+		aLine[k+1], bLine[k+1] = aLine[k], bLine[k]
+		if op.kind != '+' {
+			aLine[k+1]++
+		}
+		if op.kind != '-' {
+			bLine[k+1]++
+		}
+	}
+
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+
+		// extend the hunk until the next change is too far away to share
+		// context with this one
+		start := max(0, k-diffContext)
+		end := k
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				end = min(len(ops), end+diffContext)
+				break
+			}
+			end = next
+		}
+
+		fmt.Fprintf(w, "@@ -%s +%s @@\n",
+			hunkRange(aLine[start], aLine[end]-aLine[start]),
+			hunkRange(bLine[start], bLine[end]-bLine[start]))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(w, "%c%s\n", op.kind, op.line)
+		}
+		k = end
+	}
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start-- // NOTE: empty ranges refer to the line before, as in diff(1)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
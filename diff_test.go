@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string // the kinds of the ops
+	}{
+		{"", "", ""},
+		{"a b c", "a b c", "   "},
+		{"a b c", "a c", " - "},
+		{"a c", "a b c", " + "},
+		{"a b c", "x y z", "---+++"},
+		{"a b c d", "a x c d", " -+  "},
+	}
+	for _, tt := range tests {
+		ops := diffLines(strings.Fields(tt.a), strings.Fields(tt.b))
+		kinds := ""
+		for _, op := range ops {
+			kinds += string(op.kind)
+		}
+		if kinds != tt.want {
+			t.Errorf("diffLines(%q, %q) = %q, want %q", tt.a, tt.b, kinds, tt.want)
+		}
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+// numbered returns the lines 1 to n, each followed by a newline.
+func numbered(n int) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&sb, "l%02d\n", i)
+	}
+	return sb.String()
+}
+
+func TestWriteUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after *string
+		want          string
+	}{
+		{
+			name:   "created",
+			before: nil,
+			after:  ptr("a\nb\n"),
+			want:   "--- /dev/null\n+++ b/x\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:   "removed",
+			before: ptr("a\nb\n"),
+			after:  nil,
+			want:   "--- a/x\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name:   "created empty",
+			before: nil,
+			after:  ptr(""),
+			want:   "--- /dev/null\n+++ b/x\n",
+		},
+		{
+			name:   "emptied",
+			before: ptr("a\n"),
+			after:  ptr(""),
+			want:   "--- a/x\n+++ b/x\n@@ -1,1 +0,0 @@\n-a\n",
+		},
+		{
+			name:   "unchanged",
+			before: ptr("a\nb\n"),
+			after:  ptr("a\nb\n"),
+			want:   "--- a/x\n+++ b/x\n",
+		},
+		{
+			name:   "newline added at end",
+			before: ptr("a\nb"),
+			after:  ptr("a\nb\n"),
+			want:   "--- a/x\n+++ b/x\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name:   "line appended without newline",
+			before: ptr("a\n"),
+			after:  ptr("a\nb"),
+			want:   "--- a/x\n+++ b/x\n@@ -1,1 +1,2 @@\n a\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name:   "context is limited",
+			before: ptr(numbered(9)),
+			after:  ptr(strings.Replace(numbered(9), "l05\n", "x\n", 1)),
+			want:   "--- a/x\n+++ b/x\n@@ -3,5 +3,5 @@\n l03\n l04\n-l05\n+x\n l06\n l07\n",
+		},
+		{
+			name:   "close changes share a hunk",
+			before: ptr(numbered(12)),
+			after:  ptr(strings.NewReplacer("l03\n", "x\n", "l07\n", "y\n").Replace(numbered(12))),
+			want:   "--- a/x\n+++ b/x\n@@ -1,9 +1,9 @@\n l01\n l02\n-l03\n+x\n l04\n l05\n l06\n-l07\n+y\n l08\n l09\n",
+		},
+		{
+			name:   "distant changes get their own hunks",
+			before: ptr(numbered(12)),
+			after:  ptr(strings.NewReplacer("l02\n", "x\n", "l08\n", "y\n").Replace(numbered(12))),
+			want: "--- a/x\n+++ b/x\n" +
+				"@@ -1,4 +1,4 @@\n l01\n-l02\n+x\n l03\n l04\n" +
+				"@@ -6,5 +6,5 @@\n l06\n l07\n-l08\n+y\n l09\n l10\n",
+		},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		writeUnifiedDiff(&sb, "a/x", "b/x", tt.before, tt.after)
+		if sb.String() != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, sb.String(), tt.want)
+		}
+	}
+}
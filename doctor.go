@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// The doctor checks the integrity of the kasten. Problems that make the kasten
+// inconsistent are errors, while things that are merely unusual are warnings.
+// Some problems have safe, mechanical repairs, which are applied with --fix
+// as a single operation that can be undone.
+
+// the exit code of doctor when errors are found, as opposed to 1 for failing to
+// run the checks at all
+const doctorErrorExit = 2
+
+type finding struct {
+	id      string
+	warning bool
+	message string
+	fix     func(cs *changeSet) error // nil if there is no safe repair
+}
+
+func (f finding) String() string {
+	severity := "error"
+	if f.warning {
+		severity = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", f.id, severity, f.message)
+}
+
+// diagnose runs all checks against the index, returning the findings ordered
+// by zettel ID.
+func diagnose() ([]finding, error) {
+	ix, err := getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []finding{}
+	exists := map[string]bool{}
+	sequences := map[string][]ZettelID{}
+	parsed := map[string]ZettelID{}
+	for id := range ix.Entries {
+		exists[id] = true
+		zid, err := ParseZettelID(id)
+		if err != nil || zid.IsBranch() {
+			findings = append(findings, finding{id: id, warning: true, message: "file name is not a valid zettel ID"})
+			continue
+		}
+		parsed[id] = zid
+		sequences[zid.SequenceKey()] = append(sequences[zid.SequenceKey()], zid)
+	}
+
+	for _, id := range ix.ids() {
+		e := ix.Entries[id]
+		zid, valid := parsed[id]
+
+		seen := map[string]bool{}
+		for _, l := range e.Links {
+			if seen[l] || exists[l] || len(sequences[l]) > 0 {
+				continue
+			}
+			seen[l] = true
+			findings = append(findings, finding{id: id, message: fmt.Sprintf("broken link to [[%s]]", l)})
+		}
+
+		if !valid {
+			continue
+		}
+
+		if name, ok := e.Frontmatter["zettel"]; !ok || name != id {
+			message := "frontmatter has no zettel key"
+			if ok {
+				message = fmt.Sprintf("frontmatter says zettel %q, but the file name says %q", name, id)
+			}
+			findings = append(findings, finding{id: id, message: message, fix: func(cs *changeSet) error {
+				content, _, err := cs.read(id + ".md")
+				if err != nil {
+					return err
+				}
+				return cs.write(id+".md", updateYamlPreamble(content, id))
+			}})
+		}
+
+		if e.Frontmatter["date"] == "" {
+			date := time.Unix(0, e.ModTime).Format(timestampFormat)
+			findings = append(findings, finding{id: id, warning: true, message: "frontmatter has no date", fix: func(cs *changeSet) error {
+				content, _, err := cs.read(id + ".md")
+				if err != nil {
+					return err
+				}
+				// NOTE: the modification time is the best guess available
+				return cs.write(id+".md", editFrontmatter(id, content, func(fm *frontmatter) {
+					fm.setScalar("date", date)
+				}))
+			}})
+		}
+
+		if !zid.IsTopLevel() {
+			parent, _ := zid.Parent()
+			if !exists[parent.String()] {
+				findings = append(findings, finding{id: id, message: fmt.Sprintf("orphan, parent %s does not exist", parent)})
+			}
+		}
+	}
+
+	findings = append(findings, checkBranches(ix, sequences)...)
+	findings = append(findings, checkDuplicates(parsed)...)
+	findings = append(findings, checkGaps(sequences)...)
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].id < findings[j].id
+	})
+	return findings, nil
+}
+
+// checkBranches finds branches that are not linked from their parent, either
+// by the branch ID or by the ID of one of its members.
+func checkBranches(ix *zetIndex, sequences map[string][]ZettelID) []finding {
+	findings := []finding{}
+	for key, members := range sequences {
+		branch, err := members[0].Branch()
+		if err != nil || branch.String() != key {
+			continue // NOTE: top level sequence
+		}
+		parent, _ := branch.Parent()
+		e, ok := ix.Entries[parent.String()]
+		if !ok {
+			continue // NOTE: reported as orphans
+		}
+		linked := slices.ContainsFunc(e.Links, func(l string) bool {
+			id, err := ParseZettelID(l)
+			if err != nil {
+				return false
+			}
+			b, err := id.Branch()
+			return err == nil && b.String() == key
+		})
+		if linked {
+			continue
+		}
+		findings = append(findings, finding{id: parent.String(), warning: true, message: fmt.Sprintf("branch %s is not linked", key), fix: func(cs *changeSet) error {
+			return planLink(cs, parent.String(), key)
+		}})
+	}
+	return findings
+}
+
+// checkDuplicates finds different file names that denote the same zettel, like
+// tmp.1 and tmp.01, or Tmp.1 on case insensitive file systems.
+func checkDuplicates(parsed map[string]ZettelID) []finding {
+	byKey := map[string][]string{}
+	for id, zid := range parsed {
+		segments := []string{}
+		for _, seg := range zid.Segments {
+			if n, err := strconv.Atoi(seg); err == nil {
+				seg = strconv.Itoa(n)
+			}
+			segments = append(segments, seg)
+		}
+		key := strings.ToLower(zid.withSegments(segments).String())
+		byKey[key] = append(byKey[key], id)
+	}
+
+	findings := []finding{}
+	for _, ids := range byKey {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			others := slices.DeleteFunc(slices.Clone(ids), func(o string) bool { return o == id })
+			findings = append(findings, finding{id: id, message: fmt.Sprintf("duplicate of %s", strings.Join(others, ", "))})
+		}
+	}
+	return findings
+}
+
+// checkGaps finds missing sequence numbers in sequences, and missing branches
+// among the branches off a zettel, counting from the first ones, 1 and a.
+func checkGaps(sequences map[string][]ZettelID) []finding {
+	findings := []finding{}
+	branches := map[string][]ZettelID{} // NOTE: by parent
+	for key, members := range sequences {
+		if id, err := ParseZettelID(key); err == nil && id.IsBranch() {
+			parent, _ := id.Parent()
+			branches[parent.String()] = append(branches[parent.String()], id)
+		}
+
+		nums := []int{}
+		for _, m := range members {
+			if n, err := m.Seq(); err == nil {
+				nums = append(nums, n)
+			}
+		}
+		sort.Ints(nums)
+		if len(nums) > 0 && nums[0] > 1 {
+			nums = append([]int{0}, nums...) // NOTE: for the members before the first
+		}
+		for i := 1; i < len(nums); i++ {
+			if nums[i]-nums[i-1] <= 1 {
+				continue
+			}
+			missing := strconv.Itoa(nums[i-1] + 1)
+			if nums[i]-nums[i-1] > 2 {
+				missing += "-" + strconv.Itoa(nums[i]-1)
+			}
+			findings = append(findings, finding{id: key, warning: true, message: fmt.Sprintf("sequence is missing %s", missing)})
+		}
+	}
+
+	for parent, ids := range branches {
+		slices.SortFunc(ids, ZettelID.Compare)
+		last := ids[len(ids)-1]
+		parentId, _ := last.Parent()
+		first, err := parentId.Child("a")
+		if err != nil {
+			continue
+		}
+		missing := []string{}
+		for b := first; b.Compare(last) < 0; b, _ = b.Next() {
+			if !slices.ContainsFunc(ids, func(id ZettelID) bool { return id.Compare(b) == 0 }) {
+				missing = append(missing, b.String())
+			}
+		}
+		if len(missing) > 0 {
+			findings = append(findings, finding{id: parent, warning: true, message: fmt.Sprintf("missing branches %s", strings.Join(missing, ", "))})
+		}
+	}
+	return findings
+}
+
+var DoctorCommand = cmdtree.Cmd{
+	CommandName: "doctor",
+	Exec: func(args []string) error {
+		fix := popFlag(&args, "--fix")
+		if len(args) > 0 {
+			return fmt.Errorf("usage: zet2 doctor [--fix]")
+		}
+
+		findings, err := diagnose()
+		if err != nil {
+			return fmt.Errorf("unable to check kasten: %w", err)
+		}
+
+		if fix {
+			cs := newChangeSet("doctor", "")
+			fixed := []finding{}
+			for _, f := range findings {
+				if f.fix == nil {
+					continue
+				}
+				err = f.fix(cs)
+				if err != nil {
+					return fmt.Errorf("unable to plan fix for %q: %w", f, err)
+				}
+				fixed = append(fixed, f)
+			}
+			cs.op.Description = fmt.Sprintf("doctor --fix, %d problems repaired", len(fixed))
+			err = cs.commit()
+			if err != nil {
+				return fmt.Errorf("unable to apply fixes: %w", err)
+			}
+			for _, f := range fixed {
+				fmt.Printf("fixed %s\n", f)
+			}
+			findings, err = diagnose()
+			if err != nil {
+				return fmt.Errorf("unable to check kasten: %w", err)
+			}
+		}
+
+		errors, warnings := 0, 0
+		for _, f := range findings {
+			fmt.Println(f)
+			if f.warning {
+				warnings++
+			} else {
+				errors++
+			}
+		}
+		fmt.Printf("%d errors, %d warnings\n", errors, warnings)
+		if errors > 0 {
+			os.Exit(doctorErrorExit)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCheckGaps(t *testing.T) {
+	tests := []struct {
+		ids  []string
+		want []string // the findings, as "id: message"
+	}{
+		{[]string{"tmp.1", "tmp.2", "tmp.3"}, []string{}},
+		{[]string{"tmp.1", "tmp.3", "tmp.7"}, []string{"tmp: sequence is missing 2", "tmp: sequence is missing 4-6"}},
+		{[]string{"tmp.2", "tmp.3"}, []string{"tmp: sequence is missing 1"}},
+		{[]string{"tmp.4", "tmp.5"}, []string{"tmp: sequence is missing 1-3"}},
+		{[]string{"tmp.0", "tmp.1"}, []string{}},
+		{[]string{"tmp.1", "tmp.1a1", "tmp.1b1"}, []string{}},
+		{[]string{"tmp.1", "tmp.1a2"}, []string{"tmp.1a: sequence is missing 1"}},
+		{[]string{"tmp.1", "tmp.1b1"}, []string{"tmp.1: missing branches tmp.1a"}},
+		{[]string{"tmp.1", "tmp.1a1", "tmp.1d1"}, []string{"tmp.1: missing branches tmp.1b, tmp.1c"}},
+	}
+	for _, tt := range tests {
+		sequences := map[string][]ZettelID{}
+		for _, s := range tt.ids {
+			id := mustParse(t, s)
+			sequences[id.SequenceKey()] = append(sequences[id.SequenceKey()], id)
+		}
+		got := []string{}
+		for _, f := range checkGaps(sequences) {
+			if !f.warning {
+				t.Errorf("%q: gaps should be warnings", tt.ids)
+			}
+			got = append(got, f.id+": "+f.message)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("checkGaps(%q) = %q, want %q", tt.ids, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// The HTML export writes a static site to a directory: a page per zettel at
+// <id>.html, an index page per prefix at <prefix>/index.html, showing its
+// folgezettel tree, and a front page at index.html listing the prefixes. All
+// links are relative, so the site can be served from anywhere, or opened
+// straight from the file system.
+
+var htmlPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 46em; margin: 2em auto; padding: 0 1em; font-family: sans-serif; line-height: 1.5; color: #222; }
+nav, .meta { font-size: 0.9em; color: #666; }
+nav a { margin-right: 1em; }
+pre { background: #f4f4f4; padding: 0.5em; overflow-x: auto; }
+code { background: #f4f4f4; }
+blockquote { border-left: 3px solid #ccc; margin-left: 0; padding-left: 1em; color: #555; }
+.broken { color: #a00; }
+.id { font-family: monospace; color: #666; }
+ul.tree, ul.tree ul { list-style: none; padding-left: 1.2em; }
+</style>
+</head>
+<body>
+<nav>{{range .Nav}}<a href="{{.Href}}">{{.Label}}</a>{{end}}</nav>
+<h1>{{.Title}}</h1>
+{{with .Meta}}<p class="meta">{{.}}</p>{{end}}
+{{.Body}}
+{{if .Backlinks}}<h2>Backlinks</h2>
+<ul>
+{{range .Backlinks}}<li><a href="{{.Href}}"><span class="id">{{.ID}}</span> {{.Title}}</a></li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`))
+
+type htmlLink struct {
+	Label string
+	Href  string
+}
+
+type htmlBacklink struct {
+	ID    string
+	Title string
+	Href  string
+}
+
+type htmlPage struct {
+	Title     string
+	Meta      string
+	Nav       []htmlLink
+	Body      template.HTML
+	Backlinks []htmlBacklink
+}
+
+// htmlExport holds what's needed to export a set of zettels, and to link
+// between them.
+type htmlExport struct {
+	dir       string
+	ix        *zetIndex
+	ids       []string // in folgezettel order
+	exported  map[string]bool
+	sequences map[string][]string // the exported members of each sequence
+}
+
+func newHtmlExport(dir, prefix string) (*htmlExport, error) {
+	ix, err := getIndex()
+	if err != nil {
+		return nil, err
+	}
+	x := &htmlExport{dir: dir, ix: ix, exported: map[string]bool{}, sequences: map[string][]string{}}
+	for _, id := range ix.ids() {
+		zid, err := ParseZettelID(id)
+		if err != nil || zid.IsBranch() || !matchesPrefixes(id, []string{prefix}) {
+			continue
+		}
+		x.ids = append(x.ids, id)
+		x.exported[id] = true
+	}
+	slices.SortFunc(x.ids, compareIdStrings)
+	for _, id := range x.ids {
+		zid, _ := ParseZettelID(id)
+		x.sequences[zid.SequenceKey()] = append(x.sequences[zid.SequenceKey()], id)
+	}
+	return x, nil
+}
+
+// zettelHref returns the link to a zettel or branch from a page at the given
+// depth below the export dir, or false if it isn't exported.
+func (x *htmlExport) zettelHref(target string, depth int) (string, bool) {
+	if !x.exported[target] {
+		// NOTE: branches link to their first member
+		members := x.sequences[target]
+		if len(members) == 0 {
+			return "", false
+		}
+		target = members[0]
+	}
+	href := url.PathEscape(target) + ".html"
+	for range depth {
+		href = "../" + href
+	}
+	return href, true
+}
+
+func (x *htmlExport) write(name string, page htmlPage) error {
+	var buf bytes.Buffer
+	err := htmlPageTemplate.Execute(&buf, page)
+	if err != nil {
+		return fmt.Errorf("unable to render %q: %w", name, err)
+	}
+	filePath := path.Join(x.dir, name)
+	err = os.MkdirAll(path.Dir(filePath), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create directory for %q: %w", name, err)
+	}
+	return writeFileAtomic(filePath, buf.Bytes())
+}
+
+// zettelPage renders the page of a zettel, with links to its parent and to its
+// neighbours in the sequence.
+func (x *htmlExport) zettelPage(id string) (htmlPage, error) {
+	content, _, err := readZettelFile(id + ".md")
+	if err != nil {
+		return htmlPage{}, err
+	}
+	zid, _ := ParseZettelID(id)
+	e := x.ix.Entries[id]
+	_, body := splitFrontmatter(content)
+
+	page := htmlPage{
+		Title: id,
+		Meta:  e.Frontmatter["date"],
+		Body: template.HTML(renderMarkdown(body, func(target string) (string, bool) {
+			return x.zettelHref(target, 0)
+		})),
+	}
+	if e.Title != "" {
+		page.Title = e.Title
+	}
+	for _, t := range e.Tags {
+		page.Meta += " #" + t
+	}
+
+	page.Nav = append(page.Nav, htmlLink{Label: zid.Prefix, Href: url.PathEscape(zid.Prefix) + "/index.html"})
+	if parent, err := zid.Parent(); err == nil {
+		if href, ok := x.zettelHref(parent.String(), 0); ok {
+			page.Nav = append(page.Nav, htmlLink{Label: "parent " + parent.String(), Href: href})
+		}
+	}
+	members := x.sequences[zid.SequenceKey()]
+	i := slices.Index(members, id)
+	if i > 0 {
+		page.Nav = append(page.Nav, htmlLink{Label: "previous " + members[i-1], Href: url.PathEscape(members[i-1]) + ".html"})
+	}
+	if i+1 < len(members) {
+		page.Nav = append(page.Nav, htmlLink{Label: "next " + members[i+1], Href: url.PathEscape(members[i+1]) + ".html"})
+	}
+
+	for _, source := range x.ix.backlinksOf(zid) {
+		href, ok := x.zettelHref(source, 0)
+		if !ok {
+			continue
+		}
+		page.Backlinks = append(page.Backlinks, htmlBacklink{ID: source, Title: x.ix.Entries[source].Title, Href: href})
+	}
+	return page, nil
+}
+
+// renderTreeHtml renders a folgezettel tree as nested lists of links.
+func renderTreeHtml(nodes []*treeNode, ix *zetIndex, href func(id string) string) template.HTML {
+	var buf bytes.Buffer
+	buf.WriteString(`<ul class="tree">` + "\n")
+	for _, n := range nodes {
+		title := ""
+		if e, ok := ix.Entries[n.name()]; ok {
+			title = e.Title
+		}
+		fmt.Fprintf(&buf, `<li><a href="%s"><span class="id">%s</span> %s</a>`,
+			template.HTMLEscapeString(href(n.name())), template.HTMLEscapeString(n.name()),
+			template.HTMLEscapeString(title))
+		if len(n.Children) > 0 {
+			buf.WriteString("\n")
+			buf.WriteString(string(renderTreeHtml(n.Children, ix, href)))
+		}
+		buf.WriteString("</li>\n")
+	}
+	buf.WriteString("</ul>\n")
+	return template.HTML(buf.String())
+}
+
+// run writes every page of the export, returning the number of zettels.
+func (x *htmlExport) run() (int, error) {
+	for _, id := range x.ids {
+		page, err := x.zettelPage(id)
+		if err != nil {
+			return 0, err
+		}
+		err = x.write(id+".html", page)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	front := htmlPage{Title: "Kasten"}
+	var list bytes.Buffer
+	list.WriteString("<ul>\n")
+	for _, root := range buildTree(x.ids) {
+		err := x.write(path.Join(root.Prefix, "index.html"), htmlPage{
+			Title: root.Prefix,
+			Nav:   []htmlLink{{Label: "all prefixes", Href: "../index.html"}},
+			Body: renderTreeHtml(root.Children, x.ix, func(id string) string {
+				href, _ := x.zettelHref(id, 1)
+				return href
+			}),
+		})
+		if err != nil {
+			return 0, err
+		}
+		count := 0
+		for _, id := range x.ids {
+			if zid, _ := ParseZettelID(id); zid.Prefix == root.Prefix {
+				count++
+			}
+		}
+		fmt.Fprintf(&list, `<li><a href="%s/index.html">%s</a> (%d)</li>`+"\n",
+			template.HTMLEscapeString(url.PathEscape(root.Prefix)), template.HTMLEscapeString(root.Prefix), count)
+	}
+	list.WriteString("</ul>\n")
+	front.Body = template.HTML(list.String())
+	err := x.write("index.html", front)
+	if err != nil {
+		return 0, err
+	}
+	return len(x.ids), nil
+}
+
+var ExportCommand = cmdtree.Cmd{
+	CommandName: "export",
+	SubCommands: []*cmdtree.Cmd{
+		{
+			CommandName: "html",
+			Exec: func(args []string) error {
+				if len(args) < 1 || len(args) > 2 {
+					return fmt.Errorf("usage: zet2 export html <outdir> [prefix]")
+				}
+				prefix := ""
+				if len(args) == 2 {
+					prefix = args[1]
+				}
+				x, err := newHtmlExport(args[0], prefix)
+				if err != nil {
+					return fmt.Errorf("unable to export: %w", err)
+				}
+				if len(x.ids) == 0 {
+					return fmt.Errorf("no zettels to export")
+				}
+				count, err := x.run()
+				if err != nil {
+					return fmt.Errorf("unable to export: %w", err)
+				}
+				fmt.Printf("Exported %d zettels to %s\n", count, args[0])
+				return nil
+			},
+		},
+	},
+	Exec: func(args []string) error {
+		return fmt.Errorf("usage: zet2 export html <outdir> [prefix]")
+	},
+}
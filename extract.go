@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// parseIdRange parses a range of consecutive zettels in a sequence, given as
+// '<first>..<last>', where last is either a full ID or just the sequence
+// number, e.g. 'tmp.4..tmp.7' or 'tmp.4..7'. A single ID is a range of one.
+func parseIdRange(s string) (ZettelID, ZettelID, error) {
+	firstArg, lastArg, isRange := strings.Cut(s, "..")
+	first, err := ParseZettelID(firstArg)
+	if err != nil {
+		return first, first, fmt.Errorf("invalid start of range: %w", err)
+	}
+	if first.IsBranch() {
+		return first, first, fmt.Errorf("range start %q is a branch, not a zettel", first)
+	}
+	if !isRange {
+		return first, first, nil
+	}
+
+	var last ZettelID
+	if strings.IndexFunc(lastArg, func(r rune) bool { return !isDigit(r) }) == -1 && lastArg != "" {
+		last = first.withLeaf(lastArg)
+	} else {
+		last, err = ParseZettelID(lastArg)
+		if err != nil {
+			return first, first, fmt.Errorf("invalid end of range: %w", err)
+		}
+	}
+	if last.IsBranch() || last.SequenceKey() != first.SequenceKey() {
+		return first, first, fmt.Errorf("range end %q is not in the same sequence as %q", last, first)
+	}
+	if last.Compare(first) < 0 {
+		return first, first, fmt.Errorf("range end %q comes before its start %q", last, first)
+	}
+	return first, last, nil
+}
+
+// planExtract plans moving the zettels in the given range, along with their
+// subtrees, to a new branch of the given parent, numbered from 1. The new
+// branch is linked from the parent. Returns the new branch ID.
+func planExtract(cs *changeSet, first, last, parent ZettelID) (ZettelID, error) {
+	members, err := sequenceMembers(first.SequenceKey())
+	if err != nil {
+		return ZettelID{}, err
+	}
+	selection := []ZettelID{}
+	for _, m := range members {
+		if m.Compare(first) >= 0 && m.Compare(last) <= 0 {
+			selection = append(selection, m)
+		}
+	}
+	if len(selection) == 0 {
+		return ZettelID{}, fmt.Errorf("no zettels found between %q and %q", first, last)
+	}
+
+	for _, m := range selection {
+		if m.String() == parent.String() || m.IsAncestorOf(parent) {
+			return ZettelID{}, fmt.Errorf("cannot extract %q into its own subtree", m)
+		}
+	}
+
+	branch, err := nextFreeBranch(cs, parent)
+	if err != nil {
+		return ZettelID{}, err
+	}
+
+	moves := []subtreeMove{}
+	for i, m := range selection {
+		to, err := branch.Child(fmt.Sprint(i + 1))
+		if err != nil {
+			return ZettelID{}, fmt.Errorf("unable to number extracted zettel %q: %w", m, err)
+		}
+		moves = append(moves, subtreeMove{From: m, To: to})
+	}
+	_, err = planMoves(cs, moves, nil)
+	if err != nil {
+		return ZettelID{}, err
+	}
+
+	err = planLink(cs, parent.String(), branch.String())
+	if err != nil {
+		return ZettelID{}, fmt.Errorf("unable to link the new branch: %w", err)
+	}
+	return branch, nil
+}
+
+var ExtractCommand = cmdtree.Cmd{
+	CommandName: "extract",
+	Exec: func(args []string) error {
+		dryRun := popFlag(&args, "--dry-run", "-n")
+		if len(args) != 2 {
+			return fmt.Errorf("usage: zet2 extract [--dry-run] <first>..<last> <new-parent>")
+		}
+		first, last, err := parseIdRange(args[0])
+		if err != nil {
+			return err
+		}
+		parent, err := ParseZettelID(idFromArg(args[1]))
+		if err != nil {
+			return fmt.Errorf("invalid parent id: %w", err)
+		}
+		if parent.IsBranch() {
+			return fmt.Errorf("cannot extract into %q, as it is a branch and not a zettel", parent)
+		}
+
+		cs := newChangeSet("extract", "")
+		branch, err := planExtract(cs, first, last, parent)
+		if err != nil {
+			return fmt.Errorf("failed to plan extract: %w", err)
+		}
+		cs.op.Description = fmt.Sprintf("extract %s into %s, %d zettels moved", args[0], branch, len(cs.op.Moves))
+		if dryRun {
+			return previewOperation(cs)
+		}
+		err = cs.commit()
+		if err != nil {
+			return fmt.Errorf("failed to extract: %w", err)
+		}
+		reportOperation(cs.op)
+		return nil
+	},
+}
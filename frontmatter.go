@@ -0,0 +1,589 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// The frontmatter of a zettel is the YAML preamble between the '---' lines at
+// the top of the file. Rather than decoding the YAML, and losing comments,
+// formatting and whatever isn't understood on the way back, the preamble is
+// kept as its lines, grouped by top-level key. Only the fields that are
+// changed get rendered anew, so everything else round-trips untouched.
+
+// frontmatterField is a top-level entry in the preamble: the 'key: value' line
+// along with the lines belonging to it, like nested mappings, block lists and
+// block scalars. Comments and blank lines between fields are kept as fields
+// without a key.
+type frontmatterField struct {
+	key   string
+	value string // the value on the key line, without any comment
+	lines []string
+}
+
+type frontmatter struct {
+	fields []*frontmatterField
+}
+
+// readFrontmatter parses a preamble, as returned by splitFrontmatter.
+func readFrontmatter(front string) *frontmatter {
+	fm := &frontmatter{}
+	lines := strings.Split(strings.TrimSpace(front), "\n")
+	if len(lines) < 2 {
+		return fm
+	}
+
+	var current *frontmatterField
+	pending := []string{} // NOTE: blank lines, until we know where they belong
+	for _, line := range lines[1 : len(lines)-1] {
+		if strings.TrimSpace(line) == "" {
+			pending = append(pending, line)
+			continue
+		}
+		if current != nil && isContinuationLine(line) {
+			current.lines = append(current.lines, pending...)
+			current.lines = append(current.lines, line)
+			pending = nil
+			continue
+		}
+		for _, p := range pending {
+			fm.fields = append(fm.fields, &frontmatterField{lines: []string{p}})
+		}
+		pending = nil
+
+		current = newFrontmatterField(line)
+		fm.fields = append(fm.fields, current)
+		if current.key == "" {
+			current = nil
+		}
+	}
+	for _, p := range pending {
+		fm.fields = append(fm.fields, &frontmatterField{lines: []string{p}})
+	}
+	return fm
+}
+
+// isContinuationLine tells whether a line belongs to the field above it. Block
+// lists may be written at the same indentation as their key.
+func isContinuationLine(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") ||
+		strings.HasPrefix(line, "- ") || line == "-"
+}
+
+// newFrontmatterField parses the first line of a field. Lines that aren't a
+// 'key: value' pair make a field without a key.
+func newFrontmatterField(line string) *frontmatterField {
+	f := &frontmatterField{lines: []string{line}}
+	if line == "" || strings.ContainsRune("#- \t", rune(line[0])) {
+		return f
+	}
+
+	var key, rest string
+	if line[0] == '"' || line[0] == '\'' {
+		end := strings.IndexByte(line[1:], line[0])
+		if end == -1 {
+			return f
+		}
+		key = unquoteYaml(line[:end+2])
+		rest = strings.TrimLeft(line[end+2:], " ")
+		if !strings.HasPrefix(rest, ":") {
+			return f
+		}
+		rest = rest[1:]
+	} else {
+		i := strings.Index(line+" ", ": ")
+		if i <= 0 {
+			return f
+		}
+		key = strings.TrimSpace(line[:i])
+		rest = line[i+1:]
+	}
+	f.key = key
+	f.value = stripYamlComment(strings.TrimSpace(rest))
+	return f
+}
+
+// stripYamlComment removes a trailing comment from a value.
+func stripYamlComment(value string) string {
+	if strings.HasPrefix(value, "#") {
+		return ""
+	}
+	if value != "" && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.LastIndexByte(value, value[0]); end > 0 {
+			return value[:end+1]
+		}
+		return value
+	}
+	if i := strings.Index(value, " #"); i != -1 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// unquoteYaml removes the quotes from a quoted scalar.
+func unquoteYaml(s string) string {
+	if len(s) < 2 || s[0] != s[len(s)-1] {
+		return s
+	}
+	switch s[0] {
+	case '"':
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+		return s[1 : len(s)-1]
+	case '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+// nested returns the lines below the key line, without their common
+// indentation.
+func (f *frontmatterField) nested() []string {
+	indent := -1
+	for _, line := range f.lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if depth := len(line) - len(trimmed); indent == -1 || depth < indent {
+			indent = depth
+		}
+	}
+	ret := []string{}
+	for _, line := range f.lines[1:] {
+		if len(line) >= indent {
+			line = line[indent:]
+		} else {
+			line = ""
+		}
+		ret = append(ret, line)
+	}
+	return ret
+}
+
+// list returns the items of a field holding a flow or block list.
+func (f *frontmatterField) list() ([]string, bool) {
+	items, ok := f.rawList()
+	if !ok {
+		return nil, false
+	}
+	for i, item := range items {
+		items[i] = unquoteYaml(item)
+	}
+	return items, true
+}
+
+// rawList returns the items of a field holding a flow or block list, as they
+// are written, quotes and all.
+func (f *frontmatterField) rawList() ([]string, bool) {
+	if strings.HasPrefix(f.value, "[") {
+		flow := f.value
+		for _, line := range f.lines[1:] {
+			flow += " " + strings.TrimSpace(line)
+		}
+		return splitFlowList(flow), true
+	}
+	if f.value != "" {
+		return nil, false
+	}
+
+	items := []string{}
+	found := false
+	for _, line := range f.nested() {
+		if line == "" || strings.HasPrefix(line, "#") || line[0] == ' ' || line[0] == '\t' {
+			continue // NOTE: the content of nested items
+		}
+		item, ok := strings.CutPrefix(line, "-")
+		if !ok {
+			return nil, false // NOTE: a mapping
+		}
+		items = append(items, stripYamlComment(strings.TrimSpace(item)))
+		found = true
+	}
+	return items, found
+}
+
+// splitFlowList splits a flow list, '[a, "b, c"]', into its items, leaving
+// any quotes in place.
+func splitFlowList(flow string) []string {
+	flow = strings.TrimSpace(flow)
+	flow = strings.TrimSuffix(strings.TrimPrefix(flow, "["), "]")
+	items := []string{}
+	var quote byte
+	start := 0
+	for i := 0; i <= len(flow); i++ {
+		if i < len(flow) {
+			c := flow[i]
+			if quote != 0 {
+				if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if c == '"' || c == '\'' {
+				quote = c
+				continue
+			}
+			if c != ',' {
+				continue
+			}
+		}
+		if item := strings.TrimSpace(flow[start:i]); item != "" {
+			items = append(items, item)
+		}
+		start = i + 1
+	}
+	return items
+}
+
+// scalar returns the value of a field as a string, without quotes and with
+// block scalars joined. Lists and mappings have no scalar value.
+func (f *frontmatterField) scalar() string {
+	switch {
+	case strings.HasPrefix(f.value, "|"):
+		return strings.TrimRight(strings.Join(f.nested(), "\n"), "\n")
+	case strings.HasPrefix(f.value, ">"):
+		return strings.Join(strings.Fields(strings.Join(f.nested(), " ")), " ")
+	case strings.HasPrefix(f.value, "[") || strings.HasPrefix(f.value, "{"):
+		return ""
+	case len(f.lines) > 1:
+		if _, ok := f.list(); ok || f.value == "" {
+			return ""
+		}
+		// NOTE: a plain scalar continued over several lines
+		value := f.value
+		for _, line := range f.lines[1:] {
+			value += " " + strings.TrimSpace(line)
+		}
+		return unquoteYaml(strings.TrimSpace(value))
+	}
+	return unquoteYaml(f.value)
+}
+
+// get returns the field with the given key, or nil.
+func (fm *frontmatter) get(key string) *frontmatterField {
+	for _, f := range fm.fields {
+		if f.key != "" && f.key == key {
+			return f
+		}
+	}
+	return nil
+}
+
+// put replaces the field with the same key, or adds it at the end.
+func (fm *frontmatter) put(field *frontmatterField) {
+	for i, f := range fm.fields {
+		if f.key == field.key {
+			fm.fields[i] = field
+			return
+		}
+	}
+	fm.fields = append(fm.fields, field)
+}
+
+func (fm *frontmatter) setScalar(key, value string) {
+	fm.put(newFrontmatterField(formatYamlScalar(key, false) + ": " + formatYamlScalar(value, false)))
+}
+
+// setList sets a field to a list, keeping the style of the list it replaces.
+// Items that were in the list already are written as they were.
+func (fm *frontmatter) setList(key string, items []string) {
+	old := fm.get(key)
+	written := map[string]string{}
+	if old != nil {
+		raw, _ := old.rawList()
+		for _, r := range raw {
+			written[unquoteYaml(r)] = r
+		}
+	}
+	formatted := []string{}
+	for _, item := range items {
+		if r, ok := written[item]; ok {
+			formatted = append(formatted, r)
+			continue
+		}
+		formatted = append(formatted, formatYamlScalar(item, true))
+	}
+	keyLine := formatYamlScalar(key, false) + ":"
+
+	if old != nil && old.value == "" && len(items) > 0 {
+		if _, ok := old.list(); ok {
+			indent := old.lines[1][:len(old.lines[1])-len(strings.TrimLeft(old.lines[1], " \t"))]
+			field := newFrontmatterField(keyLine)
+			for _, item := range formatted {
+				field.lines = append(field.lines, indent+"- "+item)
+			}
+			fm.put(field)
+			return
+		}
+	}
+	fm.put(newFrontmatterField(fmt.Sprintf("%s [%s]", keyLine, strings.Join(formatted, ", "))))
+}
+
+// unset removes a field, reporting whether it was there.
+func (fm *frontmatter) unset(key string) bool {
+	for i, f := range fm.fields {
+		if f.key != "" && f.key == key {
+			fm.fields = append(fm.fields[:i], fm.fields[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (fm *frontmatter) String() string {
+	var sb strings.Builder
+	sb.WriteString("---\n")
+	for _, f := range fm.fields {
+		for _, line := range f.lines {
+			sb.WriteString(line + "\n")
+		}
+	}
+	sb.WriteString("---\n")
+	return sb.String()
+}
+
+// formatYamlScalar quotes a string if it can't be written as a plain scalar,
+// or would be read as something other than a string. Items of flow lists have
+// a few more characters that must be quoted, and are quoted if they hold a
+// space, to keep them from reading as several items.
+func formatYamlScalar(s string, flow bool) string {
+	quote := s == "" || strings.TrimSpace(s) != s ||
+		strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") ||
+		strings.ContainsAny(s, "\n\r\t") ||
+		flow && strings.ContainsAny(s, ",[]{} ") ||
+		isYamlNonString(s)
+	if quote {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// isYamlNonString tells whether a plain scalar would be read as a boolean,
+// null or number rather than a string.
+func isYamlNonString(s string) bool {
+	switch strings.ToLower(s) {
+	case "~", "null", "true", "false", "yes", "no", "on", "off", "y", "n", ".inf", "-.inf", ".nan":
+		return true
+	}
+	if !strings.ContainsAny(s[:1], "0123456789+-.") {
+		return false // NOTE: ParseFloat takes "inf" and "nan" as well
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		return true
+	}
+	_, err = strconv.ParseInt(s, 0, 64)
+	return err == nil
+}
+
+// editFrontmatter applies an edit to the preamble of a zettel, giving it one
+// if it has none, and returns the new content.
+func editFrontmatter(id, content string, edit func(fm *frontmatter)) string {
+	front, body := splitFrontmatter(content)
+	fm := readFrontmatter(front)
+	if front == "" {
+		fm.setScalar("zettel", id)
+		body = "\n" + content
+	}
+	edit(fm)
+	return fm.String() + body
+}
+
+// updateYamlPreamble sets the zettel key of the preamble to the given ID.
+func updateYamlPreamble(content, newId string) string {
+	return editFrontmatter(newId, content, func(fm *frontmatter) {
+		fm.setScalar("zettel", newId)
+	})
+}
+
+// parseFrontmatter returns the top-level scalar values of the YAML preamble of
+// a zettel, by key.
+func parseFrontmatter(content string) map[string]string {
+	front, _ := splitFrontmatter(content)
+	ret := map[string]string{}
+	for _, f := range readFrontmatter(front).fields {
+		if f.key == "" {
+			continue
+		}
+		if value := f.scalar(); value != "" {
+			ret[f.key] = value
+		}
+	}
+	return ret
+}
+
+// splitFrontmatter splits the content of a zettel into its YAML preamble,
+// including the delimiters, and the body that follows. Content without a
+// preamble is all body.
+func splitFrontmatter(content string) (string, string) {
+	rest := strings.TrimLeft(content, " \t\n")
+	if !strings.HasPrefix(rest, "---\n") {
+		return "", content
+	}
+	offset := len(content) - len(rest)
+	pos := 4
+	for pos < len(rest) {
+		end := strings.IndexByte(rest[pos:], '\n')
+		line := rest[pos:]
+		if end != -1 {
+			line = rest[pos : pos+end]
+		}
+		if strings.TrimSpace(line) == "---" {
+			if end == -1 {
+				return content, ""
+			}
+			split := offset + pos + end + 1
+			return content[:split], content[split:]
+		}
+		if end == -1 {
+			break
+		}
+		pos += end + 1
+	}
+	// NOTE: an unterminated preamble is treated as no preamble at all
+	return "", content
+}
+
+// planMetaChange adds an edit of the preamble of a zettel to the change set.
+func planMetaChange(cs *changeSet, id string, edit func(fm *frontmatter) error) error {
+	content, exists, err := cs.read(id + ".md")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("zettel %q does not exist", id)
+	}
+	var editErr error
+	content = editFrontmatter(id, content, func(fm *frontmatter) {
+		editErr = edit(fm)
+	})
+	if editErr != nil {
+		return editErr
+	}
+	return cs.write(id+".md", content)
+}
+
+// metaArgs parses the zettel and key of the meta subcommands, refusing the
+// zettel key, which follows the file name.
+func metaArgs(args []string, usage string, values bool) (string, string, error) {
+	if len(args) < 2 || !values && len(args) > 2 {
+		return "", "", fmt.Errorf("usage: %s", usage)
+	}
+	if args[1] == "zettel" {
+		return "", "", fmt.Errorf("the zettel key is kept in line with the file name, use rename instead")
+	}
+	return idFromArg(args[0]), args[1], nil
+}
+
+var MetaCommand = cmdtree.Cmd{
+	CommandName: "meta",
+	SubCommands: []*cmdtree.Cmd{
+		{
+			CommandName: "get",
+			Exec: func(args []string) error {
+				if len(args) != 2 {
+					return fmt.Errorf("usage: zet2 meta get <id> <key>")
+				}
+				id := idFromArg(args[0])
+				content, exists, err := readZettelFile(id + ".md")
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return fmt.Errorf("zettel %q does not exist", id)
+				}
+				front, _ := splitFrontmatter(content)
+				f := readFrontmatter(front).get(args[1])
+				if f == nil {
+					return fmt.Errorf("zettel %q has no %q field", id, args[1])
+				}
+				// NOTE: lists are printed an item per line, and mappings as
+				// they are written
+				if items, ok := f.list(); ok {
+					for _, item := range items {
+						fmt.Println(item)
+					}
+					return nil
+				}
+				if f.value == "" && len(f.lines) > 1 {
+					for _, line := range f.nested() {
+						fmt.Println(line)
+					}
+					return nil
+				}
+				fmt.Println(f.scalar())
+				return nil
+			},
+		},
+		{
+			CommandName: "set",
+			Exec: func(args []string) error {
+				list := popFlag(&args, "--list")
+				usage := "zet2 meta set <id> <key> <value> | zet2 meta set --list <id> <key> [item]..."
+				id, key, err := metaArgs(args, usage, true)
+				if err != nil {
+					return err
+				}
+				values := args[2:]
+				if !list && len(values) != 1 {
+					return fmt.Errorf("usage: %s", usage)
+				}
+				cs := newChangeSet("meta", fmt.Sprintf("set %s of %s", key, id))
+				err = planMetaChange(cs, id, func(fm *frontmatter) error {
+					if list {
+						fm.setList(key, values)
+					} else {
+						fm.setScalar(key, values[0])
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				return cs.commit()
+			},
+		},
+		{
+			CommandName: "unset",
+			Exec: func(args []string) error {
+				id, key, err := metaArgs(args, "zet2 meta unset <id> <key>", false)
+				if err != nil {
+					return err
+				}
+				cs := newChangeSet("meta", fmt.Sprintf("unset %s of %s", key, id))
+				err = planMetaChange(cs, id, func(fm *frontmatter) error {
+					if !fm.unset(key) {
+						return fmt.Errorf("zettel %q has no %q field", id, key)
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				return cs.commit()
+			},
+		},
+	},
+	Exec: func(args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: zet2 meta get|set|unset <id> <key> [value]")
+		}
+		id := idFromArg(args[0])
+		content, exists, err := readZettelFile(id + ".md")
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("zettel %q does not exist", id)
+		}
+		front, _ := splitFrontmatter(content)
+		fmt.Print(readFrontmatter(front).String())
+		return nil
+	},
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testPreamble = `---
+zettel: tmp.1
+# a comment, kept as it is
+title:   "Spaced  out"   # trailing comment
+tags: ["a b", c, 'D']
+nested:
+  key: value
+  list:
+    - x
+body: |
+  a block scalar
+
+  with a blank line
+"quoted key": 1
+---
+`
+
+func TestFrontmatterRoundTrip(t *testing.T) {
+	if got := readFrontmatter(testPreamble).String(); got != testPreamble {
+		t.Errorf("unedited preamble changed:\n%s\nwant\n%s", got, testPreamble)
+	}
+}
+
+// changedLines returns the lines of a that aren't in b, and the other way
+// around.
+func changedLines(a, b string) ([]string, []string) {
+	aLines, bLines := strings.Split(a, "\n"), strings.Split(b, "\n")
+	removed, added := []string{}, []string{}
+	for _, l := range aLines {
+		if !slices.Contains(bLines, l) {
+			removed = append(removed, l)
+		}
+	}
+	for _, l := range bLines {
+		if !slices.Contains(aLines, l) {
+			added = append(added, l)
+		}
+	}
+	return removed, added
+}
+
+func TestFrontmatterEditKeepsOtherLines(t *testing.T) {
+	tests := []struct {
+		name           string
+		edit           func(fm *frontmatter)
+		removed, added []string
+	}{
+		{
+			name:    "set scalar",
+			edit:    func(fm *frontmatter) { fm.setScalar("title", "New: title") },
+			removed: []string{`title:   "Spaced  out"   # trailing comment`},
+			added:   []string{`title: "New: title"`},
+		},
+		{
+			name:  "add scalar",
+			edit:  func(fm *frontmatter) { fm.setScalar("date", "2024-01-02") },
+			added: []string{"date: 2024-01-02"},
+		},
+		{
+			name:    "unset nested",
+			edit:    func(fm *frontmatter) { fm.unset("nested") },
+			removed: []string{"nested:", "  key: value", "  list:", "    - x"},
+		},
+		{
+			name:    "list keeps quoting",
+			edit:    func(fm *frontmatter) { fm.setList("tags", []string{"a b", "D", "e f", "true"}) },
+			removed: []string{`tags: ["a b", c, 'D']`},
+			added:   []string{`tags: ["a b", 'D', "e f", "true"]`},
+		},
+	}
+	for _, tt := range tests {
+		fm := readFrontmatter(testPreamble)
+		tt.edit(fm)
+		removed, added := changedLines(testPreamble, fm.String())
+		if !slices.Equal(removed, tt.removed) || !slices.Equal(added, tt.added) {
+			t.Errorf("%s: removed %q and added %q, want %q and %q", tt.name, removed, added, tt.removed, tt.added)
+		}
+	}
+}
+
+func TestFrontmatterBlockList(t *testing.T) {
+	front := "---\nzettel: tmp.1\ntags:\n  - \"a b\" # spaced\n  - c\ntitle: x\n---\n"
+	fm := readFrontmatter(front)
+	items, ok := fm.get("tags").list()
+	if !ok || !slices.Equal(items, []string{"a b", "c"}) {
+		t.Fatalf("list() = %q, %v", items, ok)
+	}
+	fm.setList("tags", []string{"a b", "d"})
+	want := "---\nzettel: tmp.1\ntags:\n  - \"a b\"\n  - d\ntitle: x\n---\n"
+	if got := fm.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatYamlScalar(t *testing.T) {
+	tests := []struct {
+		in   string
+		flow bool
+		want string
+	}{
+		{"plain", false, "plain"},
+		{"two words", false, "two words"},
+		{"two words", true, `"two words"`},
+		{"a, b", false, "a, b"},
+		{"a, b", true, `"a, b"`},
+		{"key: value", false, `"key: value"`},
+		{"#hash", false, `"#hash"`},
+		{"", false, `""`},
+		{"yes", false, `"yes"`},
+		{"Null", false, `"Null"`},
+		{"12", false, `"12"`},
+		{"1.5e3", false, `"1.5e3"`},
+		{"0x1f", false, `"0x1f"`},
+		{"inf", false, "inf"},
+		{"2024-01-02", false, "2024-01-02"},
+		{"tmp.1", false, "tmp.1"},
+	}
+	for _, tt := range tests {
+		if got := formatYamlScalar(tt.in, tt.flow); got != tt.want {
+			t.Errorf("formatYamlScalar(%q, %v) = %s, want %s", tt.in, tt.flow, got, tt.want)
+		}
+	}
+}
+
+func TestTagChangeKeepsQuoting(t *testing.T) {
+	useTestKasten(t, map[string]string{
+		"tmp.1": "---\nzettel: tmp.1\ntags: [\"a b\", c, \"x, y\"]\n---\n\nbody #inline\n",
+	})
+	front, _ := splitFrontmatter("---\nzettel: tmp.1\ntags: [\"a b\", c, \"x, y\"]\n---\n")
+	if got := frontmatterTags(front); !slices.Equal(got, []string{"a b", "c", "x, y"}) {
+		t.Errorf("frontmatterTags = %q", got)
+	}
+
+	tests := []struct {
+		add, remove []string
+		want        string
+		wantErr     bool
+	}{
+		{add: []string{"d"}, want: `tags: ["a b", c, "x, y", d]`},
+		{remove: []string{"a b"}, want: `tags: [c, "x, y"]`},
+		{remove: []string{"c"}, want: `tags: ["a b", "x, y"]`},
+		{add: []string{"c"}, remove: []string{"x, y"}, want: `tags: ["a b", c]`},
+		{remove: []string{"inline"}, wantErr: true},
+		{remove: []string{"missing"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		cs := newChangeSet("tag", "")
+		err := planTagChange(cs, "tmp.1", tt.add, tt.remove)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("add %q remove %q: expected an error", tt.add, tt.remove)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("add %q remove %q: %s", tt.add, tt.remove, err)
+			continue
+		}
+		content, _, _ := cs.read("tmp.1.md")
+		want := "---\nzettel: tmp.1\n" + tt.want + "\n---\n\nbody #inline\n"
+		if content != want {
+			t.Errorf("add %q remove %q: got\n%s\nwant\n%s", tt.add, tt.remove, content, want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// The git integration is opt-in with the git_commit config key. When enabled,
+// every operation is committed to the git repository holding the kasten as
+// soon as it's applied, staging exactly the files the operation touched, so
+// that anything else in the work tree or the git index is left alone. Failing
+// to commit doesn't fail the operation, which has already been applied by
+// then, it is only reported.
+
+func gitEnabled() bool {
+	enabled, err := strconv.ParseBool(currentConfig.value("git_commit"))
+	return err == nil && enabled
+}
+
+// git runs a git command in the zettel dir, returning its output.
+func git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = zetDir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return "", fmt.Errorf("git %s: %w", args[0], err)
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// gitCommitFiles commits the current state of the given files in the zettel
+// dir, and nothing else, if the git integration is enabled. Removed files are
+// committed as removed.
+func gitCommitFiles(message string, names ...string) error {
+	if !gitEnabled() || len(names) == 0 {
+		return nil
+	}
+
+	present, removed := []string{}, []string{}
+	for _, name := range names {
+		if fileExists(path.Join(zetDir, name)) {
+			present = append(present, name)
+		} else {
+			removed = append(removed, name)
+		}
+	}
+	if len(present) > 0 {
+		_, err := git(append([]string{"add", "--"}, present...)...)
+		if err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		// NOTE: a removed file may never have been known to git
+		_, err := git(append([]string{"rm", "--cached", "--quiet", "--ignore-unmatch", "--"}, removed...)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// NOTE: only the files that actually differ from HEAD can be given to
+	// commit, which refuses paths it doesn't know
+	out, err := git(append([]string{"diff", "--cached", "--name-only", "--no-renames", "--relative", "--"}, names...)...)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		return nil
+	}
+	_, err = git(append([]string{"commit", "--quiet", "--message", message, "--"}, strings.Split(out, "\n")...)...)
+	return err
+}
+
+// gitCommitOperation commits the files changed by an operation, described by
+// the description of the operation.
+func gitCommitOperation(op operation) {
+	names := []string{}
+	for _, c := range op.Changes {
+		names = append(names, c.Path)
+	}
+	message := op.Description
+	if message == "" {
+		message = op.Kind
+	}
+	err := gitCommitFiles(message, names...)
+	if err != nil {
+		log.Printf("%s was applied, but not committed to git: %s", op.Kind, err)
+	}
+}
+
+// commitCreated commits a newly created zettel, if git is enabled.
+func commitCreated(id string) {
+	err := gitCommitFiles("create "+id, id+".md")
+	if err != nil {
+		log.Printf("%s was created, but not committed to git: %s", id, err)
+	}
+}
+
+// gitSync brings the kasten in sync with the configured remote, by rebasing
+// the local commits onto the remote branch and pushing the result. A remote
+// branch that doesn't exist yet is created by the push.
+func gitSync() error {
+	if _, err := git("rev-parse", "--git-dir"); err != nil {
+		return fmt.Errorf("%q is not in a git repository", zetDir)
+	}
+	remote := currentConfig.value("git_remote")
+	branch, err := git("symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return fmt.Errorf("unable to determine the current branch: %w", err)
+	}
+
+	heads, err := git("ls-remote", "--heads", remote, branch)
+	if err != nil {
+		return fmt.Errorf("unable to reach remote %q: %w", remote, err)
+	}
+
+	if heads != "" {
+		_, err = git("pull", "--rebase", "--autostash", "--quiet", remote, branch)
+		if err != nil {
+			return fmt.Errorf("unable to rebase onto %s/%s, resolve it with git in %q: %w", remote, branch, zetDir, err)
+		}
+	}
+	_, err = git("push", "--quiet", remote, branch)
+	if err != nil {
+		return fmt.Errorf("unable to push to %s/%s: %w", remote, branch, err)
+	}
+	return nil
+}
+
+var SyncCommand = cmdtree.Cmd{
+	CommandName: "sync",
+	Exec: func(args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("usage: zet2 sync")
+		}
+		err := gitSync()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Synced with %s\n", currentConfig.value("git_remote"))
+		return nil
+	},
+}
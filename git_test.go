@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// useTestGit makes the test kasten a git repository with commits enabled, and
+// a bare repository as its remote, returning the path of the remote.
+func useTestGit(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	oldConfig := currentConfig
+	t.Cleanup(func() {
+		currentConfig = oldConfig
+	})
+	currentConfig = &configFile{path: path.Join(t.TempDir(), "config")}
+	currentConfig.set("git_commit", "true")
+
+	// NOTE: keeps the user's git config out of it
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(v, "zet2")
+	}
+	for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "zet2@example.org")
+	}
+
+	remote := t.TempDir()
+	runGit(t, remote, "init", "--quiet", "--bare")
+	runGit(t, zetDir, "init", "--quiet")
+	runGit(t, zetDir, "remote", "add", "origin", remote)
+	return remote
+}
+
+// runGit runs a git command in the given directory, failing the test if it
+// fails, and returns its output.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func gitLog(t *testing.T, dir string) []string {
+	t.Helper()
+	return strings.Split(runGit(t, dir, "log", "--format=%s"), "\n")
+}
+
+func TestGitCommitAndSync(t *testing.T) {
+	useTestKasten(t, map[string]string{
+		"tmp.1": "---\nzettel: tmp.1\n---\n\nfirst\n",
+	})
+	remote := useTestGit(t)
+	oldEditor := editor
+	t.Cleanup(func() {
+		editor = oldEditor
+	})
+
+	err := gitCommitFiles("start", "tmp.1.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: what a failed editor leaves behind is not committed
+	editor = "false"
+	if err := CreateCommand.Exec([]string{"tmp"}); err == nil {
+		t.Fatal("expected the failed editor to be reported")
+	}
+	if got := runGit(t, zetDir, "status", "--porcelain", "--", "tmp.2.md"); got != "?? tmp.2.md" {
+		t.Errorf("status of tmp.2.md after a failed editor = %q, want it untracked", got)
+	}
+	// NOTE: while leaving it without saving commits the zettel as created
+	editor = "true"
+	if err := CreateCommand.Exec([]string{"tmp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := newChangeSet("tag", "tag tmp.1 with idea")
+	err = planTagChange(cs, "tmp.1", []string{"idea"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"tag tmp.1 with idea", "create tmp.3", "start"}
+	if got := gitLog(t, zetDir); !slices.Equal(got, want) {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+	// NOTE: only the files of the operations are committed
+	if got := runGit(t, zetDir, "status", "--porcelain", "--untracked-files=no"); got != "" {
+		t.Errorf("uncommitted changes to tracked files: %q", got)
+	}
+
+	// NOTE: the first sync creates the remote branch
+	err = gitSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gitLog(t, remote); !slices.Equal(got, want) {
+		t.Errorf("remote log = %q, want %q", got, want)
+	}
+
+	// NOTE: a commit made elsewhere is rebased onto
+	other := t.TempDir()
+	runGit(t, other, "clone", "--quiet", remote, ".")
+	err = os.WriteFile(path.Join(other, "tmp.9.md"), []byte("other\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, other, "add", "tmp.9.md")
+	runGit(t, other, "commit", "--quiet", "--message", "elsewhere")
+	runGit(t, other, "push", "--quiet")
+
+	err = gitCommitFiles("create tmp.2", "tmp.2.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gitSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = append([]string{"create tmp.2", "elsewhere"}, want...)
+	if got := gitLog(t, remote); !slices.Equal(got, want) {
+		t.Errorf("remote log after rebasing = %q, want %q", got, want)
+	}
+	if !fileExists(path.Join(zetDir, "tmp.9.md")) {
+		t.Error("the commit made elsewhere wasn't pulled")
+	}
+}
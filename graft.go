@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// planGraft plans moving a branch, with its entire subtree, to become the next
+// free branch of another zettel. The link to the branch is removed from the old
+// parent and appended to the new one. Returns the new branch ID.
+func planGraft(cs *changeSet, branch, newParent ZettelID) (ZettelID, error) {
+	if !branch.IsBranch() {
+		return ZettelID{}, fmt.Errorf("%q is a zettel, not a branch", branch)
+	}
+	if newParent.IsBranch() {
+		return ZettelID{}, fmt.Errorf("cannot graft onto %q, as it is a branch and not a zettel", newParent)
+	}
+	if branch.IsAncestorOf(newParent) {
+		return ZettelID{}, fmt.Errorf("cannot graft %q onto its own subtree", branch)
+	}
+	oldParent, err := branch.Parent()
+	if err != nil {
+		return ZettelID{}, err
+	}
+	if oldParent.String() == newParent.String() {
+		return ZettelID{}, fmt.Errorf("%q is already a branch of %q", branch, newParent)
+	}
+
+	target, err := nextFreeBranch(cs, newParent)
+	if err != nil {
+		return ZettelID{}, err
+	}
+
+	// NOTE: the link is removed before moving, as the move would otherwise
+	// rewrite it to point at the new branch
+	oldParentFile := oldParent.String() + ".md"
+	content, exists, err := cs.read(oldParentFile)
+	if err != nil {
+		return ZettelID{}, fmt.Errorf("unable to read old parent: %w", err)
+	}
+	if exists {
+		content, _ = removeLinkLines(content, func(target string) bool {
+			return target == branch.String()
+		})
+		err = cs.write(oldParentFile, content)
+		if err != nil {
+			return ZettelID{}, err
+		}
+	}
+
+	_, err = planMoves(cs, []subtreeMove{{From: branch, To: target}}, nil)
+	if err != nil {
+		return ZettelID{}, err
+	}
+	err = planLink(cs, newParent.String(), target.String())
+	if err != nil {
+		return ZettelID{}, fmt.Errorf("unable to link the grafted branch: %w", err)
+	}
+	return target, nil
+}
+
+// removeLinkLines removes the lines that consist of nothing but a link to a
+// target matched by the given function, along with the blank line that link
+// appends to separate it from the text before. Links in running text are left
+// alone. Returns the new content and the number of lines removed.
+func removeLinkLines(content string, match func(target string) bool) (string, int) {
+	lines := strings.Split(content, "\n")
+	kept := []string{}
+	removed := 0
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || linkRegex.FindString(trimmed) != trimmed || !match(trimmed[2:len(trimmed)-2]) {
+			kept = append(kept, line)
+			continue
+		}
+		removed++
+		endOfBlock := i+1 == len(lines) || strings.TrimSpace(lines[i+1]) == ""
+		if endOfBlock && len(kept) > 0 && strings.TrimSpace(kept[len(kept)-1]) == "" {
+			kept = kept[:len(kept)-1]
+		}
+	}
+	return strings.Join(kept, "\n"), removed
+}
+
+var GraftCommand = cmdtree.Cmd{
+	CommandName: "graft",
+	Exec: func(args []string) error {
+		dryRun := popFlag(&args, "--dry-run", "-n")
+		if len(args) != 2 {
+			return fmt.Errorf("usage: zet2 graft [--dry-run] <branch> <new-parent>")
+		}
+		branch, err := ParseZettelID(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid branch id: %w", err)
+		}
+		newParent, err := ParseZettelID(idFromArg(args[1]))
+		if err != nil {
+			return fmt.Errorf("invalid parent id: %w", err)
+		}
+
+		cs := newChangeSet("graft", "")
+		target, err := planGraft(cs, branch, newParent)
+		if err != nil {
+			return fmt.Errorf("failed to plan graft: %w", err)
+		}
+		cs.op.Description = fmt.Sprintf("graft %s onto %s as %s", branch, newParent, target)
+		if dryRun {
+			return previewOperation(cs)
+		}
+		err = cs.commit()
+		if err != nil {
+			return fmt.Errorf("failed to graft: %w", err)
+		}
+		reportOperation(cs.op)
+		return nil
+	},
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// The graph of the kasten has a node per zettel and two kinds of edges: links
+// written in the zettels, and the folgezettel edges from each zettel to the
+// members of its branches, which are implied by the IDs alone.
+
+const (
+	edgeLink        = "link"
+	edgeFolgezettel = "folgezettel"
+)
+
+type graphNode struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Prefix string `json:"prefix"`
+}
+
+type graphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Kind   string `json:"kind"`
+}
+
+type zetGraph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+}
+
+// buildGraph builds the graph of the zettels in the index, or only of those in
+// the subtree of the given zettel or branch, if it isn't empty. Links to a
+// branch go to its first member, and broken links are left out.
+func buildGraph(ix *zetIndex, subtree string) (*zetGraph, error) {
+	var root ZettelID
+	if subtree != "" {
+		var err error
+		root, err = ParseZettelID(subtree)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subtree: %w", err)
+		}
+	}
+
+	ids := []ZettelID{}
+	for id := range ix.Entries {
+		zid, err := ParseZettelID(id)
+		if err != nil || zid.IsBranch() {
+			continue
+		}
+		if subtree != "" && zid.String() != root.String() && !root.IsAncestorOf(zid) {
+			continue
+		}
+		ids = append(ids, zid)
+	}
+	if len(ids) == 0 && subtree != "" {
+		return nil, fmt.Errorf("no zettels in %q", subtree)
+	}
+	slices.SortFunc(ids, ZettelID.Compare)
+
+	g := &zetGraph{Nodes: []graphNode{}, Edges: []graphEdge{}}
+	nodes := map[string]*treeNode{}
+	firstMembers := map[string]string{}
+	for _, id := range ids {
+		nodes[id.String()] = &treeNode{ID: id}
+		if _, ok := firstMembers[id.SequenceKey()]; !ok {
+			firstMembers[id.SequenceKey()] = id.String()
+		}
+		g.Nodes = append(g.Nodes, graphNode{ID: id.String(), Title: ix.Entries[id.String()].Title, Prefix: id.Prefix})
+	}
+
+	for _, id := range ids {
+		// NOTE: same as the tree, a zettel missing its parent hangs off its
+		// closest ancestor
+		if parent := closestTreeAncestor(id, nodes); parent != nil {
+			g.Edges = append(g.Edges, graphEdge{Source: parent.ID.String(), Target: id.String(), Kind: edgeFolgezettel})
+		}
+
+		seen := map[string]bool{}
+		for _, l := range ix.Entries[id.String()].Links {
+			target := l
+			if _, ok := nodes[target]; !ok {
+				target = firstMembers[l]
+			}
+			if target == "" || target == id.String() || seen[target] {
+				continue
+			}
+			seen[target] = true
+			g.Edges = append(g.Edges, graphEdge{Source: id.String(), Target: target, Kind: edgeLink})
+		}
+	}
+	return g, nil
+}
+
+// writeDot writes the graph in the DOT language of graphviz, with the zettels
+// of each prefix in a cluster, and folgezettel edges dashed.
+func (g *zetGraph) writeDot(w io.Writer) {
+	fmt.Fprintln(w, "digraph kasten {")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+	prefix := ""
+	for i, n := range g.Nodes {
+		if i == 0 || n.Prefix != prefix {
+			if i > 0 {
+				fmt.Fprintln(w, "\t}")
+			}
+			prefix = n.Prefix
+			fmt.Fprintf(w, "\tsubgraph %q {\n", "cluster_"+prefix)
+			fmt.Fprintf(w, "\t\tlabel=%q;\n", prefix)
+		}
+		label := n.ID
+		if n.Title != "" {
+			label += "\n" + n.Title
+		}
+		fmt.Fprintf(w, "\t\t%q [label=%q];\n", n.ID, label)
+	}
+	if len(g.Nodes) > 0 {
+		fmt.Fprintln(w, "\t}")
+	}
+	for _, e := range g.Edges {
+		style := ""
+		if e.Kind == edgeFolgezettel {
+			style = " [style=dashed]"
+		}
+		fmt.Fprintf(w, "\t%q -> %q%s;\n", e.Source, e.Target, style)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+type graphmlKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphmlData `xml:"data"`
+}
+
+type graphmlDoc struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphmlNode `xml:"node"`
+		Edges       []graphmlEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+func (g *zetGraph) writeGraphml(w io.Writer) error {
+	doc := graphmlDoc{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphmlKey{
+			{ID: "title", For: "node", Name: "title", Type: "string"},
+			{ID: "prefix", For: "node", Name: "prefix", Type: "string"},
+			{ID: "kind", For: "edge", Name: "kind", Type: "string"},
+		},
+	}
+	doc.Graph.ID = "kasten"
+	doc.Graph.EdgeDefault = "directed"
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphmlNode{ID: n.ID, Data: []graphmlData{
+			{Key: "title", Value: n.Title},
+			{Key: "prefix", Value: n.Prefix},
+		}})
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphmlEdge{Source: e.Source, Target: e.Target, Data: []graphmlData{
+			{Key: "kind", Value: e.Kind},
+		}})
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+var GraphCommand = cmdtree.Cmd{
+	CommandName: "graph",
+	Exec: func(args []string) error {
+		format, _, err := popFlagValue(&args, "--format")
+		if err != nil {
+			return err
+		}
+		subtree, _, err := popFlagValue(&args, "--subtree")
+		if err != nil {
+			return err
+		}
+		if len(args) > 0 {
+			return fmt.Errorf("usage: zet2 graph [--format dot|graphml|json] [--subtree id]")
+		}
+
+		ix, err := getIndex()
+		if err != nil {
+			return err
+		}
+		g, err := buildGraph(ix, idFromArg(subtree))
+		if err != nil {
+			return err
+		}
+
+		switch format {
+		case "", "dot":
+			g.writeDot(os.Stdout)
+		case "graphml":
+			err = g.writeGraphml(os.Stdout)
+			if err != nil {
+				return fmt.Errorf("unable to write graph: %w", err)
+			}
+		case "json":
+			buf, err := json.MarshalIndent(g, "", "  ")
+			if err != nil {
+				return fmt.Errorf("unable to encode graph: %w", err)
+			}
+			fmt.Println(string(buf))
+		default:
+			return fmt.Errorf("unknown graph format %q, expected dot, graphml or json", format)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// Every completed operation is kept in the history dir inside the index dir,
+// one file per operation, with the full before and after content of all files
+// it touched. This is what makes it possible to undo an operation, by applying
+// its changes in reverse. Undoing is itself an operation, recorded in the
+// history like any other, but it cannot be undone in turn.
+
+const historyDirName = "history"
+
+// the number of operations to keep in the history
+const historyLimit = 200
+
+func historyDir() string {
+	return path.Join(indexDir(), historyDirName)
+}
+
+func historyPath(op operation) string {
+	return path.Join(historyDir(), op.ID+".json")
+}
+
+func saveHistory(op operation) error {
+	err := ensureIndexDir()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(historyDir(), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to create history dir: %w", err)
+	}
+	buf, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("unable to serialize operation: %w", err)
+	}
+	err = writeFileAtomic(historyPath(op), buf)
+	if err != nil {
+		return fmt.Errorf("unable to record operation in history: %w", err)
+	}
+	return pruneHistory()
+}
+
+// pruneHistory removes the oldest operations beyond the history limit.
+func pruneHistory() error {
+	names, err := historyFiles()
+	if err != nil {
+		return err
+	}
+	for len(names) > historyLimit {
+		err = os.Remove(path.Join(historyDir(), names[0]))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to prune history: %w", err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// historyFiles returns the file names in the history dir, oldest first.
+func historyFiles() ([]string, error) {
+	entries, err := os.ReadDir(historyDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read history dir: %w", err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	// NOTE: IDs are nanosecond timestamps, compare them as numbers
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.ParseInt(strings.TrimSuffix(names[i], ".json"), 10, 64)
+		b, _ := strconv.ParseInt(strings.TrimSuffix(names[j], ".json"), 10, 64)
+		return a < b
+	})
+	return names, nil
+}
+
+// loadHistory returns the recorded operations, most recent first.
+func loadHistory() ([]operation, error) {
+	names, err := historyFiles()
+	if err != nil {
+		return nil, err
+	}
+	ops := []operation{}
+	for i := len(names) - 1; i >= 0; i-- {
+		buf, err := os.ReadFile(path.Join(historyDir(), names[i]))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read history entry %q: %w", names[i], err)
+		}
+		var op operation
+		err = json.Unmarshal(buf, &op)
+		if err != nil {
+			return nil, fmt.Errorf("history entry %q is corrupt: %w", names[i], err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func isUndoable(op operation) bool {
+	return op.Kind != "undo" && op.UndoneBy == ""
+}
+
+// undoOperation reverts the given operation. Unless forced, it refuses to do
+// so if any of the affected files have changed since the operation.
+func undoOperation(op operation, force bool) (operation, error) {
+	undo := newChangeSet("undo", "undo "+op.Description).op
+	undo.Undoes = op.ID
+
+	conflicts := []string{}
+	for i := len(op.Changes) - 1; i >= 0; i-- {
+		c := op.Changes[i]
+		undo.Changes = append(undo.Changes, fileChange{
+			Path:   c.Path,
+			Before: c.After,
+			After:  c.Before,
+		})
+
+		buf, err := os.ReadFile(path.Join(zetDir, c.Path))
+		if err != nil && !os.IsNotExist(err) {
+			return undo, fmt.Errorf("unable to read %q: %w", c.Path, err)
+		}
+		var current *string
+		if err == nil {
+			content := string(buf)
+			current = &content
+		}
+		if !sameContent(current, c.After) {
+			conflicts = append(conflicts, c.Path)
+		}
+	}
+	for i := len(op.Moves) - 1; i >= 0; i-- {
+		undo.Moves = append(undo.Moves, fileMove{From: op.Moves[i].To, To: op.Moves[i].From})
+	}
+
+	if len(conflicts) > 0 && !force {
+		return undo, fmt.Errorf("files changed since %q, undo with --force to discard those changes: %s", op.Description, strings.Join(conflicts, ", "))
+	}
+
+	err := runOperation(undo)
+	if err != nil {
+		return undo, err
+	}
+
+	op.UndoneBy = undo.ID
+	err = saveHistory(op)
+	if err != nil {
+		return undo, fmt.Errorf("undo succeeded, but marking the original operation failed: %w", err)
+	}
+	return undo, nil
+}
+
+var UndoCommand = cmdtree.Cmd{
+	CommandName: "undo",
+	Exec: func(args []string) error {
+		force := popFlag(&args, "--force", "-f")
+		count := 1
+		if len(args) > 1 {
+			return fmt.Errorf("usage: zet2 undo [--force] [count]")
+		}
+		if len(args) == 1 {
+			var err error
+			count, err = strconv.Atoi(args[0])
+			if err != nil || count < 1 {
+				return fmt.Errorf("invalid number of operations to undo: %q", args[0])
+			}
+		}
+
+		ops, err := loadHistory()
+		if err != nil {
+			return err
+		}
+		undone := 0
+		for _, op := range ops {
+			if undone == count {
+				break
+			}
+			if !isUndoable(op) {
+				continue
+			}
+			_, err := undoOperation(op, force)
+			if err != nil {
+				return fmt.Errorf("failed to undo %s: %w", op.Kind, err)
+			}
+			fmt.Printf("Undid %s\n", op.Description)
+			undone++
+		}
+		if undone == 0 {
+			return fmt.Errorf("nothing to undo")
+		}
+		if undone < count {
+			fmt.Printf("Only %d operations could be undone\n", undone)
+		}
+		return nil
+	},
+}
+
+var HistoryCommand = cmdtree.Cmd{
+	CommandName: "history",
+	Exec: func(args []string) error {
+		count := 10
+		if len(args) > 1 {
+			return fmt.Errorf("usage: zet2 history [count]")
+		}
+		if len(args) == 1 {
+			var err error
+			count, err = strconv.Atoi(args[0])
+			if err != nil || count < 1 {
+				return fmt.Errorf("invalid number of operations to show: %q", args[0])
+			}
+		}
+
+		ops, err := loadHistory()
+		if err != nil {
+			return err
+		}
+		for i, op := range ops {
+			if i == count {
+				break
+			}
+			status := ""
+			if op.UndoneBy != "" {
+				status = " (undone)"
+			}
+			fmt.Printf("%s  %-8s %s, %d files%s\n", op.Time.Local().Format("2006-01-02 15:04:05"), op.Kind, op.Description, len(op.Changes), status)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// The index is a persistent cache of everything zet2 needs to know about the
+// kasten without reading every file on every invocation: the IDs present, the
+// frontmatter of each zettel, its tags and outgoing links, and the derived
+// backlinks. It lives in a hidden directory inside the zettel dir, and is
+// refreshed incrementally by comparing modification times and sizes, so only
+// files that have actually changed since the last run are re-read.
+
+const indexDirName = ".zet2"
+const indexFileName = "index.json"
+
+// bump this whenever the layout or the semantics of the stored data changes,
+// as that will force a full rebuild on the next run
+const indexVersion = 4
+
+type indexEntry struct {
+	ID          string            `json:"id"`
+	ModTime     int64             `json:"mtime"`
+	Size        int64             `json:"size"`
+	Title       string            `json:"title,omitempty"`
+	Frontmatter map[string]string `json:"frontmatter,omitempty"`
+	Links       []string          `json:"links,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+}
+
+type zetIndex struct {
+	Version   int                    `json:"version"`
+	Entries   map[string]*indexEntry `json:"entries"`
+	Backlinks map[string][]string    `json:"backlinks"`
+
+	dir string // the zettel dir this index describes
+}
+
+// currentIndex caches the index for the lifetime of the process. It is still
+// refreshed on every access, since commands modify files as they go.
+var currentIndex *zetIndex
+
+func indexDir() string {
+	return path.Join(zetDir, indexDirName)
+}
+
+// getIndex returns an up to date index for the zettel dir, loading it from
+// disk on first use and bringing it up to date with the file system.
+func getIndex() (*zetIndex, error) {
+	if currentIndex == nil || currentIndex.dir != zetDir {
+		ix, err := loadIndex(zetDir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load index: %w", err)
+		}
+		currentIndex = ix
+	}
+	err := currentIndex.refresh()
+	if err != nil {
+		return nil, fmt.Errorf("unable to refresh index: %w", err)
+	}
+	return currentIndex, nil
+}
+
+// rebuildIndex throws away any stored index and reads every zettel anew.
+func rebuildIndex() (*zetIndex, error) {
+	currentIndex = newIndex(zetDir)
+	err := currentIndex.refresh()
+	if err != nil {
+		return nil, fmt.Errorf("unable to rebuild index: %w", err)
+	}
+	return currentIndex, nil
+}
+
+func newIndex(dir string) *zetIndex {
+	return &zetIndex{
+		Version:   indexVersion,
+		Entries:   map[string]*indexEntry{},
+		Backlinks: map[string][]string{},
+		dir:       dir,
+	}
+}
+
+func loadIndex(dir string) (*zetIndex, error) {
+	buf, err := os.ReadFile(path.Join(dir, indexDirName, indexFileName))
+	if os.IsNotExist(err) {
+		return newIndex(dir), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read index file: %w", err)
+	}
+
+	ix := &zetIndex{}
+	err = json.Unmarshal(buf, ix)
+	if err != nil || ix.Version != indexVersion || ix.Entries == nil {
+		// NOTE: a corrupt or outdated index is not an error, it is just a
+		// cache, so start over
+		return newIndex(dir), nil
+	}
+	ix.dir = dir
+	return ix, nil
+}
+
+// refresh brings the index in sync with the zettel dir, re-reading only the
+// files whose modification time or size has changed, and persists the result
+// if anything was updated.
+func (ix *zetIndex) refresh() error {
+	entries, err := os.ReadDir(ix.dir)
+	if err != nil {
+		return fmt.Errorf("unable to read zettel dir %q: %w", ix.dir, err)
+	}
+
+	dirty := false
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		id, found := strings.CutSuffix(e.Name(), ".md")
+		if !found {
+			continue
+		}
+		seen[id] = true
+
+		info, err := e.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // removed while we were looking
+			}
+			return fmt.Errorf("unable to stat %q: %w", e.Name(), err)
+		}
+		existing, ok := ix.Entries[id]
+		if ok && existing.ModTime == info.ModTime().UnixNano() && existing.Size == info.Size() {
+			continue
+		}
+
+		content, err := os.ReadFile(path.Join(ix.dir, e.Name()))
+		if err != nil {
+			return fmt.Errorf("unable to read %q for indexing: %w", e.Name(), err)
+		}
+		ix.Entries[id] = &indexEntry{
+			ID:          id,
+			ModTime:     info.ModTime().UnixNano(),
+			Size:        info.Size(),
+			Title:       zettelTitle(string(content)),
+			Frontmatter: parseFrontmatter(string(content)),
+			Links:       extractLinksFromContent(string(content)),
+			Tags:        parseTags(string(content)),
+		}
+		dirty = true
+	}
+
+	for id := range ix.Entries {
+		if !seen[id] {
+			delete(ix.Entries, id)
+			dirty = true
+		}
+	}
+
+	if !dirty {
+		return nil
+	}
+	ix.computeBacklinks()
+	return ix.save()
+}
+
+func (ix *zetIndex) computeBacklinks() {
+	ix.Backlinks = map[string][]string{}
+	for id, e := range ix.Entries {
+		seen := map[string]bool{}
+		for _, l := range e.Links {
+			if seen[l] {
+				continue
+			}
+			seen[l] = true
+			ix.Backlinks[l] = append(ix.Backlinks[l], id)
+		}
+	}
+	for _, sources := range ix.Backlinks {
+		sort.Strings(sources)
+	}
+}
+
+func (ix *zetIndex) save() error {
+	dir := path.Join(ix.dir, indexDirName)
+	err := ensureDataDir(dir)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(ix)
+	if err != nil {
+		return fmt.Errorf("unable to serialize index: %w", err)
+	}
+	return writeFileAtomic(path.Join(dir, indexFileName), buf)
+}
+
+// ensureIndexDir creates the index dir of the current zettel dir, if needed.
+func ensureIndexDir() error {
+	return ensureDataDir(indexDir())
+}
+
+func ensureDataDir(dir string) error {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to create index dir %q: %w", dir, err)
+	}
+
+	// NOTE: keep the index out of version control for kastens that live in a
+	// git repository
+	ignoreFile := path.Join(dir, ".gitignore")
+	if !fileExists(ignoreFile) {
+		err = os.WriteFile(ignoreFile, []byte("*\n"), 0644)
+		if err != nil {
+			return fmt.Errorf("unable to write %q: %w", ignoreFile, err)
+		}
+	}
+	return nil
+}
+
+// ids returns all zettel IDs in the index in lexical order.
+func (ix *zetIndex) ids() []string {
+	ret := make([]string, 0, len(ix.Entries))
+	for id := range ix.Entries {
+		ret = append(ret, id)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// linkingTo returns the IDs of all zettels that link to the given target, be
+// it a zettel or a branch ID.
+func (ix *zetIndex) linkingTo(target string) []string {
+	return ix.Backlinks[target]
+}
+
+// backlinksOf returns the IDs of all zettels linking to the given zettel,
+// either directly or to the branch it is a member of.
+func (ix *zetIndex) backlinksOf(id ZettelID) []string {
+	sources := append([]string{}, ix.linkingTo(id.String())...)
+	if branch, err := id.Branch(); err == nil && branch.String() != id.String() {
+		for _, s := range ix.linkingTo(branch.String()) {
+			if !slices.Contains(sources, s) {
+				sources = append(sources, s)
+			}
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// writeFileAtomic writes to a temporary file next to the destination and
+// renames it into place, so that readers never see a half-written file.
+func writeFileAtomic(filePath string, data []byte) error {
+	tmp, err := os.CreateTemp(path.Dir(filePath), "."+path.Base(filePath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file for %q: %w", filePath, err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write temporary file for %q: %w", filePath, err)
+	}
+	err = os.Rename(tmp.Name(), filePath)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("unable to move %q into place: %w", filePath, err)
+	}
+	return nil
+}
+
+var IndexCommand = cmdtree.Cmd{
+	CommandName: "index",
+	SubCommands: []*cmdtree.Cmd{
+		{
+			CommandName: "rebuild",
+			Exec: func(args []string) error {
+				ix, err := rebuildIndex()
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Indexed %d zettels in %q\n", len(ix.Entries), zetDir)
+				return nil
+			},
+		},
+	},
+	Exec: func(args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("unknown index subcommand %q", args[0])
+		}
+		ix, err := getIndex()
+		if err != nil {
+			return err
+		}
+		links := 0
+		for _, e := range ix.Entries {
+			links += len(e.Links)
+		}
+		fmt.Printf("%d zettels, %d links\n", len(ix.Entries), links)
+		return nil
+	},
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations that touch more than one file are never applied piecemeal.
+// Instead, they are planned in full as a changeSet, which records the content
+// of every affected file before and after the operation. Before anything is
+// written, the complete operation is written to a journal in the index dir.
+// The changes are then applied one file at a time with atomic renames, and
+// the journal is moved to the history when done. If the program dies halfway
+// through, the journal is found on the next run, and the operation is rolled
+// forward, or back if that fails, so the kasten is never left half-renamed.
+
+const journalFileName = "journal.json"
+
+// fileChange is the change of a single file in the zettel dir, given as its
+// full content before and after the operation. A nil Before means the file is
+// created, and a nil After means it is removed, so a move is recorded as a
+// removal and a creation.
+type fileChange struct {
+	Path   string  `json:"path"`
+	Before *string `json:"before"`
+	After  *string `json:"after"`
+}
+
+// fileMove records that a file was moved, for presenting the operation.
+type fileMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type operation struct {
+	ID          string       `json:"id"`
+	Kind        string       `json:"kind"`
+	Description string       `json:"description"`
+	Time        time.Time    `json:"time"`
+	Moves       []fileMove   `json:"moves,omitempty"`
+	Changes     []fileChange `json:"changes"`
+
+	// set on undo operations, the ID of the operation undone
+	Undoes string `json:"undoes,omitempty"`
+	// set on operations that have been undone, the ID of the undo operation
+	UndoneBy string `json:"undone_by,omitempty"`
+}
+
+// changeSet is an operation under construction. It overlays the zettel dir,
+// so that reads see the changes planned so far.
+type changeSet struct {
+	op    operation
+	files map[string]*fileChange
+	order []string
+}
+
+func newChangeSet(kind, description string) *changeSet {
+	now := time.Now()
+	return &changeSet{
+		op: operation{
+			ID:          strconv.FormatInt(now.UnixNano(), 10),
+			Kind:        kind,
+			Description: description,
+			Time:        now,
+		},
+		files: map[string]*fileChange{},
+	}
+}
+
+// track returns the change for the given file name, reading its current
+// content from disk the first time the file is touched.
+func (cs *changeSet) track(name string) (*fileChange, error) {
+	if c, ok := cs.files[name]; ok {
+		return c, nil
+	}
+	c := &fileChange{Path: name}
+	buf, err := os.ReadFile(path.Join(zetDir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read %q: %w", name, err)
+	}
+	if err == nil {
+		content := string(buf)
+		c.Before = &content
+		c.After = &content
+	}
+	cs.files[name] = c
+	cs.order = append(cs.order, name)
+	return c, nil
+}
+
+// read returns the content of a file as it will be after the changes planned
+// so far, and whether it exists at all.
+func (cs *changeSet) read(name string) (string, bool, error) {
+	c, err := cs.track(name)
+	if err != nil {
+		return "", false, err
+	}
+	if c.After == nil {
+		return "", false, nil
+	}
+	return *c.After, true, nil
+}
+
+func (cs *changeSet) exists(name string) bool {
+	if c, ok := cs.files[name]; ok {
+		return c.After != nil
+	}
+	return fileExists(path.Join(zetDir, name))
+}
+
+func (cs *changeSet) write(name, content string) error {
+	c, err := cs.track(name)
+	if err != nil {
+		return err
+	}
+	c.After = &content
+	return nil
+}
+
+func (cs *changeSet) remove(name string) error {
+	c, err := cs.track(name)
+	if err != nil {
+		return err
+	}
+	c.After = nil
+	return nil
+}
+
+// ids returns all zettel IDs as they will be after the planned changes.
+func (cs *changeSet) ids() ([]string, error) {
+	allIds, err := getAllIds()
+	if err != nil {
+		return nil, err
+	}
+	present := map[string]bool{}
+	for _, id := range allIds {
+		present[id] = true
+	}
+	for name, c := range cs.files {
+		id, found := strings.CutSuffix(name, ".md")
+		if !found {
+			continue
+		}
+		present[id] = c.After != nil
+	}
+	ret := []string{}
+	for id, ok := range present {
+		if ok {
+			ret = append(ret, id)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// changes returns the effective changes of the set, leaving out files that
+// end up the same as they started.
+func (cs *changeSet) changes() []fileChange {
+	ret := []fileChange{}
+	for _, name := range cs.order {
+		c := cs.files[name]
+		if c.Before == nil && c.After == nil {
+			continue
+		}
+		if c.Before != nil && c.After != nil && *c.Before == *c.After {
+			continue
+		}
+		ret = append(ret, *c)
+	}
+	return ret
+}
+
+// commit journals and applies the planned changes.
+func (cs *changeSet) commit() error {
+	cs.op.Changes = cs.changes()
+	if len(cs.op.Changes) == 0 {
+		return nil
+	}
+	return runOperation(cs.op)
+}
+
+// preview writes the planned operation as a diff, without applying anything.
+// Moved files are shown as a single diff from the old name to the new.
+func (cs *changeSet) preview(w io.Writer) {
+	changes := map[string]fileChange{}
+	for _, c := range cs.changes() {
+		changes[c.Path] = c
+	}
+	shown := map[string]bool{}
+	for _, m := range cs.op.Moves {
+		from, to := changes[m.From], changes[m.To]
+		writeUnifiedDiff(w, "a/"+m.From, "b/"+m.To, from.Before, to.After)
+		shown[m.To] = true
+		// NOTE: the old name may be reused by another move in the same
+		// operation, in which case it is shown as the target of that
+		if from.After == nil {
+			shown[m.From] = true
+		}
+	}
+	for _, name := range cs.order {
+		c, ok := changes[name]
+		if !ok || shown[name] {
+			continue
+		}
+		writeUnifiedDiff(w, "a/"+name, "b/"+name, c.Before, c.After)
+	}
+}
+
+// runOperation applies an operation under the protection of the journal.
+func runOperation(op operation) error {
+	err := writeJournal(op)
+	if err != nil {
+		return fmt.Errorf("unable to write journal, nothing was changed: %w", err)
+	}
+
+	err = applyChanges(op.Changes, true)
+	if err != nil {
+		rollbackErr := applyChanges(op.Changes, false)
+		if rollbackErr != nil {
+			return fmt.Errorf("%s failed: %w, and rolling back failed as well: %s. The journal is kept for recovery on the next run", op.Kind, err, rollbackErr)
+		}
+		// NOTE: a journal left behind would be rolled forward on the next run,
+		// redoing what was just rolled back
+		journalErr := removeJournal()
+		if journalErr != nil {
+			return fmt.Errorf("%s failed, all changes were rolled back: %w, but %s. Remove %q before running zet2 again", op.Kind, err, journalErr, journalPath())
+		}
+		return fmt.Errorf("%s failed, all changes were rolled back: %w", op.Kind, err)
+	}
+
+	return finishOperation(op)
+}
+
+// finishOperation is called when all changes of an operation are applied. The
+// operation is moved from the journal to the history, so that it can be undone,
+// and committed to git if enabled.
+func finishOperation(op operation) error {
+	err := saveHistory(op)
+	if err != nil {
+		return err
+	}
+	err = removeJournal()
+	if err != nil {
+		return err
+	}
+	gitCommitOperation(op)
+	return nil
+}
+
+// applyChanges brings every file in the list to its state after the
+// operation, or before it if forward is false. All writes are done before any
+// removals, so that content is never lost if interrupted. Applying the same
+// changes again is harmless.
+func applyChanges(changes []fileChange, forward bool) error {
+	target := func(c fileChange) *string {
+		if forward {
+			return c.After
+		}
+		return c.Before
+	}
+	for _, c := range changes {
+		content := target(c)
+		if content == nil {
+			continue
+		}
+		err := writeFileAtomic(path.Join(zetDir, c.Path), []byte(*content))
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range changes {
+		if target(c) != nil {
+			continue
+		}
+		err := os.Remove(path.Join(zetDir, c.Path))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove %q: %w", c.Path, err)
+		}
+	}
+	return nil
+}
+
+func journalPath() string {
+	return path.Join(indexDir(), journalFileName)
+}
+
+func writeJournal(op operation) error {
+	err := ensureIndexDir()
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("unable to serialize journal: %w", err)
+	}
+	return writeFileAtomic(journalPath(), buf)
+}
+
+func removeJournal() error {
+	err := os.Remove(journalPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove journal: %w", err)
+	}
+	return nil
+}
+
+// recoverJournal completes an operation that was interrupted, if any. Changes
+// are rolled forward if every affected file is either untouched or already
+// updated, and rolled back if rolling forward fails. If any of the files have
+// been changed by something else in the meantime, it is up to the user to
+// sort it out.
+func recoverJournal() error {
+	buf, err := os.ReadFile(journalPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read journal: %w", err)
+	}
+	var op operation
+	err = json.Unmarshal(buf, &op)
+	if err != nil {
+		return fmt.Errorf("journal %q is corrupt: %w", journalPath(), err)
+	}
+
+	for _, c := range op.Changes {
+		buf, err := os.ReadFile(path.Join(zetDir, c.Path))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to read %q: %w", c.Path, err)
+		}
+		var current *string
+		if err == nil {
+			content := string(buf)
+			current = &content
+		}
+		if !sameContent(current, c.Before) && !sameContent(current, c.After) {
+			return fmt.Errorf("interrupted %s (%s) cannot be recovered, as %q has been modified since. Inspect the journal in %q and remove it when done", op.Kind, op.Description, c.Path, journalPath())
+		}
+	}
+
+	err = applyChanges(op.Changes, true)
+	if err == nil {
+		log.Printf("Recovered interrupted %s by completing it: %s", op.Kind, op.Description)
+		return finishOperation(op)
+	}
+
+	rollbackErr := applyChanges(op.Changes, false)
+	if rollbackErr != nil {
+		return fmt.Errorf("unable to roll interrupted %s forward (%s) or back (%s)", op.Kind, err, rollbackErr)
+	}
+	log.Printf("Recovered interrupted %s by rolling it back: %s", op.Kind, op.Description)
+	return removeJournal()
+}
+
+func sameContent(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// Named kastens are stored in the config file as 'kasten.<name> = <dir>', and
+// the one to use by default is given by the 'kasten' key. The special name
+// 'default' refers to the directory given by the 'dir' key, which is used when
+// no kasten is selected.
+
+const kastenKeyPrefix = "kasten."
+const defaultKastenName = "default"
+
+// the name of the kasten in use for this invocation
+var kastenName = defaultKastenName
+
+// resolveKasten determines the zettel dir from the config and the name given
+// by the --kasten option, which may be empty.
+func resolveKasten(cfg *configFile, name string) (string, error) {
+	if name == "" {
+		name, _ = cfg.get("kasten")
+	}
+	if name == "" || name == defaultKastenName {
+		kastenName = defaultKastenName
+		return cfg.value("dir"), nil
+	}
+	dir, ok := cfg.get(kastenKeyPrefix + name)
+	if !ok {
+		return "", fmt.Errorf("unknown kasten %q, see 'zet2 kasten list'", name)
+	}
+	kastenName = name
+	return dir, nil
+}
+
+func validateKastenName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty kasten name")
+	}
+	if name == defaultKastenName {
+		return fmt.Errorf("%q is reserved for the kasten in the 'dir' config key", name)
+	}
+	if strings.ContainsAny(name, ". \t=#/") {
+		return fmt.Errorf("kasten name %q contains invalid characters", name)
+	}
+	return nil
+}
+
+// kastens returns the names of all named kastens in the config, mapped to
+// their directories.
+func kastens(cfg *configFile) map[string]string {
+	ret := map[string]string{}
+	for _, k := range cfg.keys() {
+		name, ok := strings.CutPrefix(k, kastenKeyPrefix)
+		if !ok {
+			continue
+		}
+		ret[name], _ = cfg.get(k)
+	}
+	return ret
+}
+
+var KastenCommand = cmdtree.Cmd{
+	CommandName: "kasten",
+	SubCommands: []*cmdtree.Cmd{
+		{
+			CommandName: "list",
+			Exec: func(args []string) error {
+				all := kastens(currentConfig)
+				all[defaultKastenName] = currentConfig.value("dir")
+				names := []string{}
+				for name := range all {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					marker := " "
+					if name == kastenName {
+						marker = "*"
+					}
+					fmt.Printf("%s %-12s %s\n", marker, name, all[name])
+				}
+				return nil
+			},
+		},
+		{
+			CommandName: "add",
+			Exec: func(args []string) error {
+				if len(args) != 2 {
+					return fmt.Errorf("usage: zet2 kasten add <name> <dir>")
+				}
+				name, dir := args[0], args[1]
+				err := validateKastenName(name)
+				if err != nil {
+					return err
+				}
+				if _, exists := currentConfig.get(kastenKeyPrefix + name); exists {
+					return fmt.Errorf("kasten %q already exists", name)
+				}
+				// NOTE: stored as an absolute path, since a relative one would
+				// be taken from wherever zet2 happens to be run later
+				dir, err = expandHome(dir)
+				if err != nil {
+					return err
+				}
+				dir, err = filepath.Abs(dir)
+				if err != nil {
+					return fmt.Errorf("unable to resolve %q: %w", args[1], err)
+				}
+				info, err := os.Stat(dir)
+				if err != nil || !info.IsDir() {
+					return fmt.Errorf("%q is not a directory", dir)
+				}
+				currentConfig.set(kastenKeyPrefix+name, dir)
+				return currentConfig.save()
+			},
+		},
+		{
+			CommandName: "use",
+			Exec: func(args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("usage: zet2 kasten use <name>")
+				}
+				name := args[0]
+				if name == defaultKastenName {
+					currentConfig.unset("kasten")
+					return currentConfig.save()
+				}
+				if _, exists := currentConfig.get(kastenKeyPrefix + name); !exists {
+					return fmt.Errorf("unknown kasten %q", name)
+				}
+				currentConfig.set("kasten", name)
+				return currentConfig.save()
+			},
+		},
+	},
+	Exec: func(args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("unknown kasten subcommand %q", args[0])
+		}
+		fmt.Printf("%s %s\n", kastenName, zetDir)
+		return nil
+	},
+}
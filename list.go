@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/morngrar/zet2/cmdtree"
+	"golang.org/x/term"
+)
+
+// isHeading tells whether a line is a markdown heading. The hashes must be
+// followed by a space, so that inline tags like '#go' don't count.
+func isHeading(line string) bool {
+	rest := strings.TrimLeft(line, "#")
+	return rest != line && (rest == "" || strings.HasPrefix(rest, " "))
+}
+
+// zettelTitle returns the title of a zettel: the title field of its preamble,
+// or else its first heading, or else its first non-empty line.
+func zettelTitle(content string) string {
+	front, body := splitFrontmatter(content)
+	if title := parseFrontmatter(front)["title"]; title != "" {
+		return title
+	}
+	inCode := false
+	for line := range strings.SplitSeq(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inCode = !inCode
+			continue
+		}
+		if !inCode && isHeading(trimmed) {
+			if title := strings.TrimSpace(strings.TrimLeft(trimmed, "#")); title != "" {
+				return title
+			}
+		}
+	}
+	return firstLine(content)
+}
+
+// listEntry is a zettel as printed by the list command, and what is available
+// to its --format templates.
+type listEntry struct {
+	ID    string   `json:"id"`
+	Title string   `json:"title"`
+	Date  string   `json:"date,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	Path  string   `json:"path"`
+}
+
+// listEntries returns the zettels with the given prefix, or all of them if it's
+// empty, in folgezettel order.
+func listEntries(prefix string) ([]listEntry, error) {
+	ix, err := getIndex()
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for id := range ix.Entries {
+		if matchesPrefixes(id, []string{prefix}) {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, compareIdStrings)
+
+	entries := []listEntry{}
+	for _, id := range ids {
+		e := ix.Entries[id]
+		entries = append(entries, listEntry{
+			ID:    id,
+			Title: e.Title,
+			Date:  e.Frontmatter["date"],
+			Tags:  e.Tags,
+			Path:  zettelPath(id),
+		})
+	}
+	return entries, nil
+}
+
+var ListCommand = cmdtree.Cmd{
+	CommandName: "list",
+	Exec: func(args []string) error {
+		asJson := popFlag(&args, "--json")
+		format, hasFormat, err := popFlagValue(&args, "--format")
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 || asJson && hasFormat {
+			return fmt.Errorf("usage: zet2 list [--json|--format template] [prefix]")
+		}
+		prefix := ""
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+
+		entries, err := listEntries(prefix)
+		if err != nil {
+			return fmt.Errorf("unable to list zettels: %w", err)
+		}
+
+		if asJson {
+			buf, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				return fmt.Errorf("unable to encode zettels: %w", err)
+			}
+			fmt.Println(string(buf))
+			return nil
+		}
+
+		if hasFormat {
+			tmpl, err := template.New("format").Funcs(template.FuncMap{"join": strings.Join}).Parse(format)
+			if err != nil {
+				return fmt.Errorf("invalid format: %w", err)
+			}
+			for _, e := range entries {
+				err = tmpl.Execute(os.Stdout, e)
+				if err != nil {
+					return fmt.Errorf("unable to format %q: %w", e.ID, err)
+				}
+				fmt.Println()
+			}
+			return nil
+		}
+
+		idWidth := 0
+		for _, e := range entries {
+			idWidth = max(idWidth, len(e.ID))
+		}
+		width := 0
+		if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			width = w
+		}
+		for _, e := range entries {
+			line := e.ID
+			if e.Title != "" {
+				line = padRight(e.ID, idWidth) + "  " + e.Title
+			}
+			if width > 0 {
+				line = truncateRunes(line, width)
+			}
+			fmt.Println(line)
+		}
+		return nil
+	},
+}
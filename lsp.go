@@ -0,0 +1,724 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// zet2 lsp is a language server for the wiki links between zettels, speaking
+// JSON-RPC over stdin and stdout. It works on the zettel dir of the current
+// kasten, and on the documents open in the editor, whose unsaved content takes
+// precedence over what is on disk.
+
+// the JSON-RPC error codes used
+const (
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+	lspRequestFailed  = -32803
+)
+
+// the type of the messages shown with window/showMessage
+const lspMessageInfo = 3
+
+// partialLinkRegex matches an unfinished link right before the cursor.
+var partialLinkRegex = regexp.MustCompile(`\[\[([a-zA-Z0-9.\-_]*)$`)
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string {
+	return e.Message
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+// lspPositionParams covers the params of every request made at a position in a
+// document, along with the extra fields of the ones that have them.
+type lspPositionParams struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+	NewName  string      `json:"newName"`
+	Context  struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type lspDocument struct {
+	version int
+	text    string
+}
+
+type lspServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	dir      string // the absolute zettel dir
+	docs     map[string]*lspDocument
+	shutdown bool
+
+	// whether the client takes versioned edits in workspace edits
+	documentChanges bool
+}
+
+func newLspServer(in io.Reader, out io.Writer) (*lspServer, error) {
+	dir, err := filepath.Abs(zetDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve zettel dir: %w", err)
+	}
+	return &lspServer{
+		in:   bufio.NewReader(in),
+		out:  out,
+		dir:  dir,
+		docs: map[string]*lspDocument{},
+	}, nil
+}
+
+// read reads the next message, framed by a Content-Length header.
+func (s *lspServer) read() (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid content length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without content length")
+	}
+	buf := make([]byte, length)
+	_, err := io.ReadFull(s.in, buf)
+	if err != nil {
+		return nil, err
+	}
+	msg := &lspMessage{}
+	err = json.Unmarshal(buf, msg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *lspServer) write(msg *lspMessage) error {
+	msg.JSONRPC = "2.0"
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(buf), buf)
+	return err
+}
+
+func (s *lspServer) notify(method string, params any) error {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.write(&lspMessage{Method: method, Params: buf})
+}
+
+// serve handles messages until the client exits, or the input ends.
+func (s *lspServer) serve() error {
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "zet2 lsp: %s: %s\n", msg.Method, err)
+			}
+			continue
+		}
+		reply := &lspMessage{ID: msg.ID, Result: result}
+		if err != nil {
+			lerr, ok := err.(*lspError)
+			if !ok {
+				lerr = &lspError{Code: lspRequestFailed, Message: err.Error()}
+			}
+			reply.Result = nil
+			reply.Error = lerr
+		} else if result == nil {
+			reply.Result = json.RawMessage("null")
+		}
+		err = s.write(reply)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *lspServer) handle(msg *lspMessage) (any, error) {
+	switch msg.Method {
+	case "initialize":
+		return s.initialize(msg.Params)
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI     string `json:"uri"`
+				Version int    `json:"version"`
+				Text    string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		s.docs[p.TextDocument.URI] = &lspDocument{version: p.TextDocument.Version, text: p.TextDocument.Text}
+		return nil, s.publishDiagnostics(p.TextDocument.URI)
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument struct {
+				URI     string `json:"uri"`
+				Version int    `json:"version"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		if len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		// NOTE: full sync, so the last change is the whole document
+		s.docs[p.TextDocument.URI] = &lspDocument{
+			version: p.TextDocument.Version,
+			text:    p.ContentChanges[len(p.ContentChanges)-1].Text,
+		}
+		return nil, s.publishDiagnostics(p.TextDocument.URI)
+	case "textDocument/didSave":
+		var p lspPositionParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.publishDiagnostics(p.TextDocument.URI)
+	case "textDocument/didClose":
+		var p lspPositionParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return nil, s.notify("textDocument/publishDiagnostics", map[string]any{
+			"uri":         p.TextDocument.URI,
+			"diagnostics": []any{},
+		})
+	}
+
+	handlers := map[string]func(p lspPositionParams) (any, error){
+		"textDocument/definition": s.definition,
+		"textDocument/completion": s.completion,
+		"textDocument/hover":      s.hover,
+		"textDocument/references": s.references,
+		"textDocument/rename":     s.rename,
+	}
+	if handler, ok := handlers[msg.Method]; ok {
+		var p lspPositionParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, &lspError{Code: lspInvalidParams, Message: err.Error()}
+		}
+		return handler(p)
+	}
+	if msg.ID == nil {
+		return nil, nil // NOTE: notifications we don't care about
+	}
+	return nil, &lspError{Code: lspMethodNotFound, Message: fmt.Sprintf("method %q not supported", msg.Method)}
+}
+
+func (s *lspServer) initialize(params json.RawMessage) (any, error) {
+	var p struct {
+		Capabilities struct {
+			Workspace struct {
+				WorkspaceEdit struct {
+					DocumentChanges bool `json:"documentChanges"`
+				} `json:"workspaceEdit"`
+			} `json:"workspace"`
+		} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &lspError{Code: lspInvalidParams, Message: err.Error()}
+	}
+	s.documentChanges = p.Capabilities.Workspace.WorkspaceEdit.DocumentChanges
+
+	return map[string]any{
+		"capabilities": map[string]any{
+			"textDocumentSync": map[string]any{
+				"openClose": true,
+				"change":    1, // NOTE: full
+				"save":      true,
+			},
+			"definitionProvider": true,
+			"completionProvider": map[string]any{
+				"triggerCharacters": []string{"["},
+			},
+			"hoverProvider":      true,
+			"referencesProvider": true,
+			"renameProvider":     true,
+		},
+		"serverInfo": map[string]any{
+			"name":    "zet2",
+			"version": version,
+		},
+	}, nil
+}
+
+// uriToId returns the zettel ID of a document, if it is in the zettel dir.
+func (s *lspServer) uriToId(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	dir, name := filepath.Split(filepath.FromSlash(u.Path))
+	id, found := strings.CutSuffix(name, ".md")
+	if !found || filepath.Clean(dir) != s.dir {
+		return "", false
+	}
+	return id, true
+}
+
+func (s *lspServer) idToUri(id string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(s.dir, id+".md"))}
+	return u.String()
+}
+
+// text returns the content of a zettel, as open in the editor if it is.
+func (s *lspServer) text(id string) (string, bool, error) {
+	if doc, ok := s.docs[s.idToUri(id)]; ok {
+		return doc.text, true, nil
+	}
+	return readZettelFile(id + ".md")
+}
+
+func (s *lspServer) documentText(uri string) (string, error) {
+	if doc, ok := s.docs[uri]; ok {
+		return doc.text, nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid document %q: %w", uri, err)
+	}
+	buf, err := os.ReadFile(filepath.FromSlash(u.Path))
+	if err != nil {
+		return "", fmt.Errorf("unable to read %q: %w", uri, err)
+	}
+	return string(buf), nil
+}
+
+// utf16Column converts a byte offset in a line to the UTF-16 column used by
+// the protocol.
+func utf16Column(line string, offset int) int {
+	col := 0
+	for _, r := range line[:offset] {
+		col += utf16.RuneLen(r)
+	}
+	return col
+}
+
+// byteOffset converts a UTF-16 column in a line to a byte offset.
+func byteOffset(line string, col int) int {
+	n := 0
+	for i, r := range line {
+		if n >= col {
+			return i
+		}
+		n += utf16.RuneLen(r)
+	}
+	return len(line)
+}
+
+// linkAt returns the target of the link at the given position, if any.
+func (s *lspServer) linkAt(p lspPositionParams) (string, bool, error) {
+	text, err := s.documentText(p.TextDocument.URI)
+	if err != nil {
+		return "", false, err
+	}
+	lines := strings.Split(text, "\n")
+	if p.Position.Line >= len(lines) {
+		return "", false, nil
+	}
+	line := lines[p.Position.Line]
+	offset := byteOffset(line, p.Position.Character)
+	for _, m := range linkRegex.FindAllStringSubmatchIndex(line, -1) {
+		if offset >= m[0] && offset <= m[1] {
+			return line[m[2]:m[3]], true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// resolveTarget returns the zettel a link leads to, which for a branch is its
+// first member.
+func resolveTarget(ix *zetIndex, target string) (string, bool) {
+	if _, ok := ix.Entries[target]; ok {
+		return target, true
+	}
+	members := []string{}
+	for id := range ix.Entries {
+		zid, err := ParseZettelID(id)
+		if err == nil && zid.SequenceKey() == target {
+			members = append(members, id)
+		}
+	}
+	if len(members) == 0 {
+		return "", false
+	}
+	slices.SortFunc(members, compareIdStrings)
+	return members[0], true
+}
+
+func (s *lspServer) definition(p lspPositionParams) (any, error) {
+	target, ok, err := s.linkAt(p)
+	if err != nil || !ok {
+		return nil, err
+	}
+	ix, err := getIndex()
+	if err != nil {
+		return nil, err
+	}
+	id, ok := resolveTarget(ix, target)
+	if !ok {
+		return nil, nil
+	}
+	return lspLocation{URI: s.idToUri(id)}, nil
+}
+
+func (s *lspServer) completion(p lspPositionParams) (any, error) {
+	text, err := s.documentText(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(text, "\n")
+	if p.Position.Line >= len(lines) {
+		return []any{}, nil
+	}
+	line := lines[p.Position.Line]
+	offset := byteOffset(line, p.Position.Character)
+	m := partialLinkRegex.FindStringSubmatchIndex(line[:offset])
+	if m == nil {
+		return []any{}, nil
+	}
+	closing := "]]"
+	if strings.HasPrefix(line[offset:], "]]") {
+		closing = ""
+	}
+	editRange := lspRange{
+		Start: lspPosition{Line: p.Position.Line, Character: utf16Column(line, m[2])},
+		End:   p.Position,
+	}
+
+	ix, err := getIndex()
+	if err != nil {
+		return nil, err
+	}
+	ids := ix.ids()
+	slices.SortFunc(ids, compareIdStrings)
+	items := []map[string]any{}
+	for i, id := range ids {
+		title := ix.Entries[id].Title
+		items = append(items, map[string]any{
+			"label":      id,
+			"kind":       18, // NOTE: reference
+			"detail":     title,
+			"filterText": id + " " + title,
+			"sortText":   fmt.Sprintf("%06d", i),
+			"textEdit":   lspTextEdit{Range: editRange, NewText: id + closing},
+		})
+	}
+	return map[string]any{"isIncomplete": false, "items": items}, nil
+}
+
+// the number of lines of a zettel shown when hovering a link to it
+const lspHoverLines = 20
+
+func (s *lspServer) hover(p lspPositionParams) (any, error) {
+	target, ok, err := s.linkAt(p)
+	if err != nil || !ok {
+		return nil, err
+	}
+	ix, err := getIndex()
+	if err != nil {
+		return nil, err
+	}
+	id, ok := resolveTarget(ix, target)
+	if !ok {
+		return map[string]any{"contents": map[string]string{
+			"kind":  "markdown",
+			"value": fmt.Sprintf("`%s` does not exist", target),
+		}}, nil
+	}
+	content, _, err := s.text(id)
+	if err != nil {
+		return nil, err
+	}
+	_, body := splitFrontmatter(content)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) > lspHoverLines {
+		lines = append(lines[:lspHoverLines], "…")
+	}
+	value := fmt.Sprintf("**%s**", id)
+	if title := zettelTitle(content); title != "" {
+		value += " " + title
+	}
+	value += "\n\n---\n\n" + strings.Join(lines, "\n")
+	return map[string]any{"contents": map[string]string{"kind": "markdown", "value": value}}, nil
+}
+
+// references finds the links to the zettel linked at the position, or to the
+// zettel of the document itself if there is no link there.
+func (s *lspServer) references(p lspPositionParams) (any, error) {
+	target, ok, err := s.linkAt(p)
+	if err != nil {
+		return nil, err
+	}
+	ix, err := getIndex()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		target, ok = resolveTarget(ix, target)
+	} else {
+		target, ok = s.uriToId(p.TextDocument.URI)
+	}
+	if !ok {
+		return []lspLocation{}, nil
+	}
+	id, err := ParseZettelID(target)
+	if err != nil {
+		return []lspLocation{}, nil
+	}
+
+	targets := map[string]bool{target: true}
+	if branch, err := id.Branch(); err == nil {
+		targets[branch.String()] = true
+	}
+	locations := []lspLocation{}
+	if p.Context.IncludeDeclaration {
+		locations = append(locations, lspLocation{URI: s.idToUri(target)})
+	}
+	for _, source := range ix.backlinksOf(id) {
+		content, exists, err := s.text(source)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		for i, line := range strings.Split(content, "\n") {
+			for _, m := range linkRegex.FindAllStringSubmatchIndex(line, -1) {
+				if !targets[line[m[2]:m[3]]] {
+					continue
+				}
+				locations = append(locations, lspLocation{URI: s.idToUri(source), Range: lspRange{
+					Start: lspPosition{Line: i, Character: utf16Column(line, m[0])},
+					End:   lspPosition{Line: i, Character: utf16Column(line, m[1])},
+				}})
+			}
+		}
+	}
+	return locations, nil
+}
+
+// publishDiagnostics reports the broken links of a document.
+func (s *lspServer) publishDiagnostics(uri string) error {
+	if _, ok := s.uriToId(uri); !ok {
+		return nil
+	}
+	text, err := s.documentText(uri)
+	if err != nil {
+		return err
+	}
+	ix, err := getIndex()
+	if err != nil {
+		return err
+	}
+	diagnostics := []map[string]any{}
+	for i, line := range strings.Split(text, "\n") {
+		for _, m := range linkRegex.FindAllStringSubmatchIndex(line, -1) {
+			target := line[m[2]:m[3]]
+			if _, ok := resolveTarget(ix, target); ok {
+				continue
+			}
+			diagnostics = append(diagnostics, map[string]any{
+				"range": lspRange{
+					Start: lspPosition{Line: i, Character: utf16Column(line, m[0])},
+					End:   lspPosition{Line: i, Character: utf16Column(line, m[1])},
+				},
+				"severity": 1, // NOTE: error
+				"source":   "zet2",
+				"message":  fmt.Sprintf("broken link to [[%s]]", target),
+			})
+		}
+	}
+	return s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": diagnostics})
+}
+
+// wholeRange returns the range covering all of the given text.
+func wholeRange(text string) lspRange {
+	lines := strings.Split(text, "\n")
+	last := lines[len(lines)-1]
+	return lspRange{End: lspPosition{Line: len(lines) - 1, Character: utf16Column(last, len(last))}}
+}
+
+// rename renames the zettel linked at the position, or the zettel of the
+// document. The rename is planned by renameZettel and applied on the server
+// side, as zet2 rename would, so that it is journaled, recorded in the history
+// and committed to git. The edit handed back only brings the open buffers of
+// the rewritten zettels up to date, since the files are in place already. The
+// buffers of moved zettels can't follow, and the client is told to reopen them.
+func (s *lspServer) rename(p lspPositionParams) (any, error) {
+	target, ok, err := s.linkAt(p)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		target, ok = s.uriToId(p.TextDocument.URI)
+	}
+	if !ok {
+		return nil, fmt.Errorf("no zettel to rename here")
+	}
+	newName := strings.TrimSpace(p.NewName)
+	if newName == "" {
+		return nil, &lspError{Code: lspInvalidParams, Message: "no new zettel ID given"}
+	}
+
+	cs, err := renameZettel(target, newName)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: renameZettel plans from what is on disk, so unsaved changes would
+	// be overwritten
+	for _, c := range cs.changes() {
+		uri := s.idToUri(strings.TrimSuffix(c.Path, ".md"))
+		if doc, ok := s.docs[uri]; ok && c.Before != nil && doc.text != *c.Before {
+			return nil, fmt.Errorf("%s has unsaved changes, save it before renaming", c.Path)
+		}
+	}
+
+	err = cs.commit()
+	if err != nil {
+		return nil, err
+	}
+
+	stale := []string{}
+	for _, m := range cs.op.Moves {
+		if _, ok := s.docs[s.idToUri(strings.TrimSuffix(m.From, ".md"))]; ok {
+			stale = append(stale, fmt.Sprintf("%s (now %s)", strings.TrimSuffix(m.From, ".md"), strings.TrimSuffix(m.To, ".md")))
+		}
+	}
+	edits := []any{}
+	changes := map[string][]lspTextEdit{}
+	for _, c := range cs.changes() {
+		uri := s.idToUri(strings.TrimSuffix(c.Path, ".md"))
+		doc, ok := s.docs[uri]
+		if !ok || c.Before == nil || c.After == nil {
+			continue // NOTE: not open, or moved
+		}
+		edit := lspTextEdit{Range: wholeRange(*c.Before), NewText: *c.After}
+		if s.documentChanges {
+			edits = append(edits, map[string]any{
+				"textDocument": map[string]any{"uri": uri, "version": doc.version},
+				"edits":        []lspTextEdit{edit},
+			})
+		} else {
+			changes[uri] = []lspTextEdit{edit}
+		}
+	}
+
+	message := fmt.Sprintf("Renamed %s to %s", target, newName)
+	if len(stale) > 0 {
+		message += ". These open zettels have moved, close them without saving: " + strings.Join(stale, ", ")
+	}
+	err = s.notify("window/showMessage", map[string]any{"type": lspMessageInfo, "message": message})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.documentChanges {
+		return map[string]any{"documentChanges": edits}, nil
+	}
+	return map[string]any{"changes": changes}, nil
+}
+
+var LspCommand = cmdtree.Cmd{
+	CommandName: "lsp",
+	Exec: func(args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("usage: zet2 lsp")
+		}
+		s, err := newLspServer(os.Stdin, os.Stdout)
+		if err != nil {
+			return err
+		}
+		return s.serve()
+	},
+}
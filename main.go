@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,11 +11,12 @@ import (
 	"path"
 	"regexp"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
+	"unicode/utf8"
 
 	"github.com/morngrar/zet2/cmdtree"
 	"golang.org/x/term"
@@ -27,28 +29,50 @@ var DEBUG = os.Getenv("ZET2_DEBUG") == "1" || os.Getenv("ZET2_DEBUG") == "true"
 var editor = os.Getenv("EDITOR")
 var zetDir = "./zettel"
 var defaultPrefix = "tmp"
+var timestampFormat = "Mon 2006-01-02 15:04:05 MST"
 var version = "v0.7.1"
 
-var sequenceUpperLimit = 999999
-
 // prefixes that are disallowed because they will come in conflict with
 // subcommands
 var reservedPrefixes = []string{
+	"backlinks",
 	"branch",
+	"browse",
+	"config",
+	"doctor",
+	"export",
+	"extract",
+	"graft",
 	"link",
+	"lsp",
+	"graph",
 	"grep",
+	"meta",
 	"next",
 	"previous",
 	"rename",
 	"replant",
+	"search",
+	"serve",
+	"sync",
+	"tag",
+	"tagged",
+	"tags",
 	"version",
 	"--version",
 	"-v",
 	"resolve",
 	"open",
+	"tree",
 	"help",
+	"history",
+	"index",
+	"kasten",
 	"path",
 	"leaf",
+	"list",
+	"prune",
+	"undo",
 	"--help",
 	"-h",
 }
@@ -57,13 +81,30 @@ func main() {
 	log.SetFlags(0) // turn off timestamping log statements, this is a cli app
 	var err error
 
-	if !DEBUG {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			log.Fatalf("Unable to retrieve user's home directory: %s", err)
+	args, options, err := extractGlobalOptions(os.Args)
+	if err != nil {
+		log.Fatalf("Invalid arguments: %s", err)
+	}
+	os.Args = args
+
+	cfgPath, err := configPath(options["--config"])
+	if err != nil {
+		log.Fatalf("Unable to determine config file location: %s", err)
+	}
+	cfg, err := loadConfig(cfgPath)
+	if err != nil {
+		log.Fatalf("Unable to load config: %s", err)
+	}
+	err = applyConfig(cfg, options["--kasten"])
+	if err != nil {
+		// NOTE: the config and kasten commands don't need a kasten, and are
+		// the way to fix a broken one
+		if len(args) < 2 || (args[1] != ConfigCommand.CommandName && args[1] != KastenCommand.CommandName) {
+			log.Fatalf("Unable to apply config: %s", err)
 		}
-		zetDir = path.Join(homeDir, "zettel2") // NOTE: temporary prod-dir until 1.0, then the trailing 2 will be dropped in command and dir
-		// TODO: make this configurable
+		log.Printf("Warning: %s", err)
+		ZetCommand.CompleteOrRun()
+		return
 	}
 
 	err = os.MkdirAll(zetDir, os.ModePerm) // ensures existence of zettel dir
@@ -71,6 +112,11 @@ func main() {
 		log.Fatalf("Unable to ensure zettel dir '%s': %s", zetDir, err)
 	}
 
+	err = recoverJournal()
+	if err != nil {
+		log.Fatalf("Unable to recover from interrupted operation: %s", err)
+	}
+
 	ZetCommand.CompleteOrRun()
 }
 
@@ -83,14 +129,37 @@ var ZetCommand = cmdtree.Cmd{
 	CommandName: "zet",
 	SubCommands: []*cmdtree.Cmd{
 		&CreateCommand,
+		&BacklinksCommand,
 		&BranchCommand,
+		&BrowseCommand,
+		&ConfigCommand,
+		&DoctorCommand,
+		&ExportCommand,
+		&ExtractCommand,
+		&GraftCommand,
+		&GraphCommand,
 		&GrepCommand,
+		&HistoryCommand,
+		&IndexCommand,
+		&KastenCommand,
 		&LinkCommand,
+		&LspCommand,
 		&LeafCommand,
+		&ListCommand,
+		&MetaCommand,
 		&OpenCommand,
+		&PruneCommand,
 		&RenameCommand,
 		&ReplantCommand,
 		&ResolveCommand,
+		&SearchCommand,
+		&ServeCommand,
+		&SyncCommand,
+		&TagCommand,
+		&TaggedCommand,
+		&TagsCommand,
+		&TreeCommand,
+		&UndoCommand,
 		{
 			CommandName: "version",
 			Exec:        printVersion,
@@ -122,15 +191,25 @@ var BranchCommand = cmdtree.Cmd{
 					return fmt.Errorf("unable to shift off parent id for branch link command: %w", err)
 				}
 
-				branchId, err := createZettelBranchFile(parentId)
+				parentId = idFromArg(parentId)
+				cs := newChangeSet("branch", "")
+				branchId, err := planBranch(cs, parentId)
 				if err != nil {
 					return fmt.Errorf("error while creating branch file: %w", err)
 				}
+				err = planLink(cs, parentId, branchId)
+				if err != nil {
+					return fmt.Errorf("error while linking branch: %w", err)
+				}
+				cs.op.Description = fmt.Sprintf("branch %s off %s, linked from %s", branchId, parentId, parentId)
+				err = cs.commit()
+				if err != nil {
+					return err
+				}
 
-				linkAndAppend(parentId, branchId)
 				beginning, err := getFirstSeqInBranch(branchId)
 				if err != nil {
-					panic(err) // should NEVER happen
+					return fmt.Errorf("unable to find the new branch: %w", err)
 				}
 				newFile := fmt.Sprintf("%s.md", beginning)
 				filePath := path.Join(zetDir, newFile)
@@ -154,228 +233,268 @@ var BranchCommand = cmdtree.Cmd{
 		if err != nil {
 			return fmt.Errorf("Error getting first argument of branch command: %w", err)
 		}
-		branchId, err := createZettelBranchFile(parentId)
+		parentId = idFromArg(parentId)
+		cs := newChangeSet("branch", "")
+		branchId, err := planBranch(cs, parentId)
 		if err != nil {
 			return fmt.Errorf("error while creating branch file: %w", err)
 		}
+		cs.op.Description = fmt.Sprintf("branch %s off %s", branchId, parentId)
+		err = cs.commit()
+		if err != nil {
+			return err
+		}
 
 		fmt.Printf("[[%s]]\n", branchId)
 		return nil
 	},
 }
 
-func createZettelBranchFile(parentId string) (branchId string, err error) {
-	if strings.HasSuffix(parentId, ".md") {
-		base := path.Base(parentId)
-		parentId, _ = strings.CutSuffix(base, ".md")
+// idFromArg takes a zettel ID or a path to a zettel file and returns the ID.
+func idFromArg(arg string) string {
+	if strings.HasSuffix(arg, ".md") {
+		base := path.Base(arg)
+		arg, _ = strings.CutSuffix(base, ".md")
 	}
+	return arg
+}
 
-	fileName := fmt.Sprintf("%s.md", parentId)
-	filePath := path.Join(zetDir, fileName)
-	byteContent, err := os.ReadFile(filePath)
+// popFlag removes all occurrences of the given flag names from the argument
+// list, and reports whether any were found.
+func popFlag(args *[]string, names ...string) bool {
+	found := false
+	rest := []string{}
+	for _, a := range *args {
+		if slices.Contains(names, a) {
+			found = true
+			continue
+		}
+		rest = append(rest, a)
+	}
+	*args = rest
+	return found
+}
+
+// popFlagValue removes a flag taking a value from the argument list, given
+// either as '--flag value' or '--flag=value', and returns the value and
+// whether the flag was found.
+func popFlagValue(args *[]string, name string) (string, bool, error) {
+	value := ""
+	found := false
+	rest := []string{}
+	for i := 0; i < len(*args); i++ {
+		a := (*args)[i]
+		if v, ok := strings.CutPrefix(a, name+"="); ok {
+			value, found = v, true
+			continue
+		}
+		if a != name {
+			rest = append(rest, a)
+			continue
+		}
+		if i+1 == len(*args) {
+			return "", false, fmt.Errorf("flag %s requires a value", name)
+		}
+		i++
+		value, found = (*args)[i], true
+	}
+	*args = rest
+	return value, found, nil
+}
+
+// planBranch adds the creation of a new branch off the given parent zettel to
+// the change set, returning the ID of the new branch.
+func planBranch(cs *changeSet, parentId string) (branchId string, err error) {
+	parent, err := ParseZettelID(parentId)
 	if err != nil {
-		return branchId, fmt.Errorf("unable to open parent '%s' for branching: %w", filePath, err)
+		return branchId, fmt.Errorf("invalid parent id: %w", err)
+	}
+	if parent.IsBranch() {
+		return branchId, fmt.Errorf("cannot branch off %q, as it is a branch and not a zettel", parentId)
 	}
 
-	content := string(byteContent)
-	links := extractLinksFromContent(content)
-	branches, err := filterBranches(links, parentId)
+	next, err := nextFreeBranch(cs, parent)
+	if err != nil {
+		return branchId, err
+	}
+	first, err := next.Child("1") // start branches on sequence no. 1
 	if err != nil {
-		return branchId, fmt.Errorf("unable to filter branches: %w", err)
+		return branchId, fmt.Errorf("unable to determine first zettel of branch %q: %w", next, err)
 	}
-	next, err := nextBranch(branches)
+	branchId = next.String()
+	firstFile := first.String() + ".md"
+	if cs.exists(firstFile) {
+		return branchId, fmt.Errorf("attempted to create existing file: %s", firstFile)
+	}
+	content, _, err := newZettelContent("branch", first.String(), "", cs.read)
 	if err != nil {
-		return branchId, fmt.Errorf("unable to calculate next branch: %w", err)
+		return branchId, fmt.Errorf("unable to make content of new zettel %q: %w", first, err)
 	}
-	branchId = parentId + next
-	_, err = createZettelFile(branchId + "1") // start branches on sequence no. 1
+	err = cs.write(firstFile, content)
 	if err != nil {
 		return branchId, fmt.Errorf("error while creating zettel file for branch %q: %w", branchId, err)
 	}
 	return branchId, nil
 }
 
-// finds the lowest number in a sequence or branch, and returns it along with
-// if the sequence prefix is a dotted one or not. Returns error if the list of
-// entries is empty, or if the prefix is not found.
-func findLowestNumInSeq(prefix string, entries []os.DirEntry) (int, bool, error) {
-
-	if len(entries) == 0 {
-		return 0, false, fmt.Errorf("entries was empty")
-	}
-
-	minNum := sequenceUpperLimit
-	dotSeparated := true
-	numberPrefix := unicode.IsDigit(rune(prefix[len(prefix)-1]))
-	prefixFound := false
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
-		}
-		suffix, found := strings.CutPrefix(e.Name(), prefix)
-		if !found {
-			continue
-		}
-		prefixFound = true
-		if suffix[0] == '.' {
-			suffix = suffix[1:]
-		} else {
-			if !numberPrefix {
-				dotSeparated = false
-			}
-		}
-		if !unicode.IsDigit(rune(suffix[0])) {
-			continue
-		}
-		id, _ := strings.CutSuffix(suffix, ".md")
-		num, err := strconv.Atoi(id)
-		if err != nil {
-			continue
-		}
-		if num < minNum {
-			minNum = num
-		}
+// nextFreeBranch returns the next unused branch on the given parent zettel, as
+// it will be after the changes planned so far.
+func nextFreeBranch(cs *changeSet, parent ZettelID) (ZettelID, error) {
+	fileName := parent.String() + ".md"
+	content, exists, err := cs.read(fileName)
+	if err != nil {
+		return ZettelID{}, fmt.Errorf("unable to open parent '%s' for branching: %w", fileName, err)
 	}
-
-	if !prefixFound {
-		return minNum, dotSeparated, fmt.Errorf("prefix %q not found", prefix)
+	if !exists {
+		return ZettelID{}, fmt.Errorf("parent %q does not exist", parent)
 	}
 
-	return minNum, dotSeparated, nil
-}
-
-// finds the highest number in a sequence or branch, and returns it along with
-// if the sequence prefix is a dotted one or not. Returns error if the list of
-// entries is empty, or if the prefix is not found.
-func findHighestNumInSeq(prefix string, entries []os.DirEntry) (int, bool, error) {
-
-	if len(entries) == 0 {
-		return 0, false, fmt.Errorf("entries was empty")
-	}
+	links := extractLinksFromContent(content)
+	branches := filterBranches(links, parent)
 
-	maxNum := 0
-	dotSeparated := true
-	numberPrefix := unicode.IsDigit(rune(prefix[len(prefix)-1]))
-	prefixFound := false
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
-		}
-		suffix, found := strings.CutPrefix(e.Name(), prefix)
-		if !found {
-			continue
-		}
-		prefixFound = true
-		if suffix[0] == '.' {
-			suffix = suffix[1:]
-		} else {
-			if !numberPrefix {
-				dotSeparated = false
-			}
-		}
-		if !unicode.IsDigit(rune(suffix[0])) {
-			continue
-		}
-		id, _ := strings.CutSuffix(suffix, ".md")
-		num, err := strconv.Atoi(id)
-		if err != nil {
-			continue
-		}
-		if num > maxNum {
-			maxNum = num
-		}
+	// NOTE: branches may exist on disk without being linked from the parent,
+	// and those must not be clobbered either
+	allIds, err := cs.ids()
+	if err != nil {
+		return ZettelID{}, fmt.Errorf("unable to find existing branches: %w", err)
 	}
+	branches = append(branches, branchesIn(allIds, parent)...)
 
-	if !prefixFound {
-		return maxNum, dotSeparated, fmt.Errorf("prefix %q not found", prefix)
+	next, err := nextBranch(parent, branches)
+	if err != nil {
+		return ZettelID{}, fmt.Errorf("unable to calculate next branch: %w", err)
 	}
-
-	return maxNum, dotSeparated, nil
+	return next, nil
 }
 
 var CreateCommand = cmdtree.Cmd{
 	CommandName: "create",
 	Exec: func(args []string) error {
-		entries, err := os.ReadDir(zetDir)
+		title, _, err := popFlagValue(&args, "--title")
 		if err != nil {
-			return fmt.Errorf("unable to read zettel dir %q: %w", zetDir, err)
+			return err
 		}
-
 		prefix, err := cmdtree.SliceShift(&args)
 		if err != nil {
 			return fmt.Errorf("expected prefix to be an argument, error encountered while shifting it: %w", err)
 		}
-
-		// NOTE: check against reserved stuff
-		for _, e := range reservedPrefixes {
-			if e == prefix {
-				return fmt.Errorf("reserved prefix")
-			}
-		}
-
-		maxNum, dotSeparated, err := findHighestNumInSeq(prefix, entries)
+		id, filePath, cursor, err := createZettel(prefix, title)
 		if err != nil {
-			// NOTE: first zettel with given prefix
-			dotSeparated = true
-			maxNum = 0
-		}
-		var zettelId string
-		if dotSeparated {
-			zettelId = fmt.Sprintf("%s.%d", prefix, maxNum+1)
-		} else {
-			zettelId = fmt.Sprintf("%s%d", prefix, maxNum+1)
+			return err
 		}
-		filePath, err := createZettelFile(zettelId)
+		err = openInEditorAt(filePath, cursor, true)
 		if err != nil {
-			return fmt.Errorf("error while creating file while creating new zettel: %w", err)
+			return fmt.Errorf("%s was created, but the editor failed: %w", id, err)
 		}
-		return openInEditor(filePath, true)
+		// NOTE: committed after editing, so that the commit holds what was
+		// written rather than an empty zettel. A failed editor may have left
+		// anything behind, which is left to the user to commit
+		commitCreated(id)
+		return nil
 	},
 }
 
 var GrepCommand = cmdtree.Cmd{
 	CommandName: "grep",
 	Exec: func(args []string) error {
+		ignoreCase := popFlag(&args, "-i", "--ignore-case")
+		idsOnly := popFlag(&args, "-l", "--ids-only")
+		skipFrontmatter := popFlag(&args, "--skip-frontmatter")
+		contextArg, hasContext, err := popFlagValue(&args, "-C")
+		if err != nil {
+			return err
+		}
+		prefix, _, err := popFlagValue(&args, "--prefix")
+		if err != nil {
+			return err
+		}
+		subtreeArg, hasSubtree, err := popFlagValue(&args, "--subtree")
+		if err != nil {
+			return err
+		}
+
 		grepTerm, err := cmdtree.SliceShift(&args)
 		if err != nil {
 			return fmt.Errorf("error while shifting off grep term from args: %w", err)
 		}
+		if ignoreCase {
+			grepTerm = "(?i)" + grepTerm
+		}
 		re, err := regexp.Compile(grepTerm)
 		if err != nil {
 			return fmt.Errorf("unable to compile regex term: %w", err)
 		}
-		terminalWidth, _, err := term.GetSize(0)
-		if err != nil {
-			return fmt.Errorf("error while getting size of terminal: %w", err)
+		context := 0
+		if hasContext {
+			context, err = strconv.Atoi(contextArg)
+			if err != nil || context < 0 {
+				return fmt.Errorf("invalid number of context lines %q", contextArg)
+			}
 		}
-		entries, err := os.ReadDir(zetDir)
+		var subtreeRoot ZettelID
+		if hasSubtree {
+			subtreeRoot, err = ParseZettelID(idFromArg(subtreeArg))
+			if err != nil {
+				return fmt.Errorf("invalid subtree: %w", err)
+			}
+		}
+
+		// NOTE: only truncate and highlight when output is for a human, so
+		// that grep works in pipes
+		terminalWidth, _, err := term.GetSize(int(os.Stdout.Fd()))
+		tty := err == nil
+
+		ids, err := getAllIds()
 		if err != nil {
-			return fmt.Errorf("unable to read zettel dir %q: %w", zetDir, err)
+			return fmt.Errorf("unable to list zettels: %w", err)
 		}
-		for _, e := range entries {
-			if e.IsDir() {
+		for _, id := range ids {
+			if !matchesPrefixes(id, []string{prefix}) {
 				continue
 			}
-			id, found := strings.CutSuffix(e.Name(), ".md")
-			if !found {
-				continue
+			if hasSubtree {
+				zid, err := ParseZettelID(id)
+				if err != nil || (zid.String() != subtreeRoot.String() && !subtreeRoot.IsAncestorOf(zid)) {
+					continue
+				}
 			}
-			contentBytes, err := os.ReadFile(path.Join(zetDir, e.Name()))
+
+			contentBytes, err := os.ReadFile(path.Join(zetDir, id+".md"))
 			if err != nil {
 				return fmt.Errorf("error while reading file: %w", err)
 			}
-			if re.Match(contentBytes) {
-				content := string(contentBytes)
-				for _, line := range strings.Split(content, "\n") {
-					if re.MatchString(line) {
-						prefix := fmt.Sprintf("%s: ", id)
-						truncLimit := terminalWidth - len(prefix)
-						line = strings.TrimSpace(line)
-						if len(line) > truncLimit {
-							line = line[:truncLimit-3] + "..."
-						}
-						fmt.Printf("%s%s\n", prefix, line)
+			content := string(contentBytes)
+			front := ""
+			if skipFrontmatter {
+				front, content = splitFrontmatter(content)
+			}
+			if !re.MatchString(content) {
+				continue
+			}
+			if idsOnly {
+				fmt.Println(id)
+				continue
+			}
+
+			// NOTE: line numbers are kept relative to the whole file
+			offset := strings.Count(front, "\n")
+			lines := strings.Split(content, "\n")
+			printed := -1
+			for i, line := range lines {
+				if !re.MatchString(line) {
+					continue
+				}
+				start := max(printed+1, i-context)
+				if context > 0 && printed != -1 && start > printed+1 {
+					fmt.Println("--")
+				}
+				for j := start; j <= min(len(lines)-1, i+context); j++ {
+					sep := "-"
+					if re.MatchString(lines[j]) {
+						sep = ":"
 					}
+					fmt.Println(formatGrepLine(id, offset+j+1, sep, lines[j], re, tty, terminalWidth))
+					printed = j
 				}
 			}
 		}
@@ -383,64 +502,30 @@ var GrepCommand = cmdtree.Cmd{
 	},
 }
 
+// formatGrepLine formats a line of grep output. On a terminal, the line is cut
+// to fit, and the matches are highlighted.
+func formatGrepLine(id string, lineNum int, sep, line string, re *regexp.Regexp, tty bool, width int) string {
+	prefix := fmt.Sprintf("%s%s%d%s ", id, sep, lineNum, sep)
+	line = strings.TrimSpace(line)
+	if !tty {
+		return prefix + line
+	}
+	if width > 0 {
+		line = truncateRunes(line, max(4, width-utf8.RuneCountInString(prefix)))
+	}
+	line = re.ReplaceAllStringFunc(line, func(m string) string {
+		return ansiMatch + m + ansiReset
+	})
+	return ansiDim + prefix + ansiReset + line
+}
+
 func retryOpenPrefix(id string) error {
-	idsMatchingPrefix, err := getIdsMatchingPrefix(id)
+	first, err := getFirstSeqInBranch(id)
 	if err != nil {
-		return fmt.Errorf("failed getting ids matching prefix %q: %w", id, err)
-	}
-	if len(idsMatchingPrefix) == 0 {
-		return fmt.Errorf("file doesn't exist, and no matching prefixes found.")
-	}
-
-	// NOTE: find the start of the shortest matching id
-	// TODO: the for loop below looks a lot like `getFirstSeqInBranch`, refactoring opportunity
-	minNum := sequenceUpperLimit
-	var base string
-	for _, e := range idsMatchingPrefix {
-		var seq string
-		var err error
-		base, seq, _, err = stripLeaf(e)
-		if err != nil {
-			log.Printf("Unable to strip sequence number: %s", err)
-		}
-
-		// NOTE: only sequences where the base is a match for the id are
-		// interesting, because otherwise we'll match grandchildren
-		if base != id+"." {
-			continue
-		}
-
-		num, err := strconv.Atoi(seq)
-		if err != nil {
-			continue
-		}
-
-		if num == sequenceUpperLimit {
-			panic("unexpected high sequence number encountered. logic should be re-evaluated")
-		}
-
-		if num < minNum {
-			minNum = num
-		}
-		if num == 0 {
-			break
-		}
-	}
-
-	if base != id && base != id+"." {
-		base = id + "."
+		// NOTE: i tried.
+		return fmt.Errorf("neither file, nor matching sequence exist: %q", id)
 	}
-
-	if minNum < sequenceUpperLimit {
-		newFile := fmt.Sprintf("%s%d.md", base, minNum)
-		filePath := path.Join(zetDir, newFile)
-		if fileExists(filePath) {
-			return openInEditor(filePath, false)
-		}
-	}
-
-	// NOTE: i tried.
-	return fmt.Errorf("neither file, nor matching sequence exist: %q", id)
+	return openInEditor(path.Join(zetDir, first+".md"), false)
 }
 
 func filterPassthrough() error {
@@ -503,42 +588,40 @@ var LinkCommand = cmdtree.Cmd{
 		if err != nil {
 			return fmt.Errorf("failed to shift off destination ID in link command: %w", err)
 		}
-		return linkAndAppend(srcId, dstId)
+		cs := newChangeSet("link", fmt.Sprintf("link %s -> %s", srcId, dstId))
+		err = planLink(cs, srcId, dstId)
+		if err != nil {
+			return err
+		}
+		return cs.commit()
 	},
 }
 
-func linkAndAppend(srcId, dstId string) error {
-	srcPath := path.Join(zetDir, srcId+".md")
-	if !fileExists(srcPath) {
-		return fmt.Errorf("source zet does not exist: %q", srcPath)
+// planLink adds appending a link to the destination zettel or branch to the
+// source zettel to the change set.
+func planLink(cs *changeSet, srcId, dstId string) error {
+	srcFile := srcId + ".md"
+	content, exists, err := cs.read(srcFile)
+	if err != nil {
+		return fmt.Errorf("unable to read source zet: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("source zet does not exist: %q", srcFile)
 	}
 
-	dstPath := path.Join(zetDir, dstId+".md")
-	if !fileExists(dstPath) {
-		_, err := getFirstSeqInBranch(dstId)
+	if !cs.exists(dstId + ".md") {
+		allIds, err := cs.ids()
+		if err != nil {
+			return fmt.Errorf("unable to list zettels: %w", err)
+		}
+		_, err = membersIn(allIds, dstId)
 		if err != nil {
 			return fmt.Errorf("destination %q does not exist: %w", dstId, err)
 		}
 	}
 
 	link := fmt.Sprintf("\n[[%s]]\n", dstId)
-	err := appendToFile(link, srcPath)
-	if err != nil {
-		return fmt.Errorf("unable to append link: %w", err)
-	}
-	return nil
-}
-
-func appendToFile(s string, path string) error {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	if _, err := f.WriteString(s); err != nil {
-		return fmt.Errorf("failed to append string to %q: %w", path, err)
-	}
-	return nil
+	return cs.write(srcFile, content+link)
 }
 
 var OpenCommand = cmdtree.Cmd{
@@ -549,15 +632,6 @@ var OpenCommand = cmdtree.Cmd{
 		if err != nil {
 			return fmt.Errorf("error while shifting off id to open: %w", err)
 		}
-		if !unicode.IsDigit(rune(id[len(id)-1])) {
-			// NOTE: not a sequence no. so must be branch
-			tmpId, err := getFirstSeqInBranch(id)
-			if err != nil {
-				// NOTE: may be an all-letter prefix
-				return retryOpenPrefix(id)
-			}
-			id = tmpId // NOTE: success should preserve new id
-		}
 
 		// NOTE: happy path, just open the file
 		filePath := path.Join(zetDir, id+".md")
@@ -565,7 +639,8 @@ var OpenCommand = cmdtree.Cmd{
 			return openInEditor(filePath, false)
 		}
 
-		// NOTE: attempt to be clever when user tries to open valid prefix
+		// NOTE: attempt to be clever when user tries to open a branch or a
+		// valid prefix
 		return retryOpenPrefix(id)
 	},
 }
@@ -573,6 +648,7 @@ var OpenCommand = cmdtree.Cmd{
 var RenameCommand = cmdtree.Cmd{
 	CommandName: "rename",
 	Exec: func(args []string) error {
+		dryRun := popFlag(&args, "--dry-run", "-n")
 		from, err := cmdtree.SliceShift(&args)
 		if err != nil {
 			return fmt.Errorf("error while shifting off from id: %w", err)
@@ -581,211 +657,178 @@ var RenameCommand = cmdtree.Cmd{
 		if err != nil {
 			return fmt.Errorf("error while shifting off to id: %w", err)
 		}
-		renamedChildren := []string{}
-		err = renameZettel(zetDir, from, to, &renamedChildren)
+		cs, err := renameZettel(from, to)
 		if err != nil {
-			return fmt.Errorf("failed to perform recursive rename: %w", err)
+			return fmt.Errorf("failed to plan rename: %w", err)
 		}
-
-		// for later
-		// TODO: journaler
-		// TODO: journal remover
-
+		if dryRun {
+			return previewOperation(cs)
+		}
+		err = cs.commit()
+		if err != nil {
+			return fmt.Errorf("failed to rename: %w", err)
+		}
+		reportOperation(cs.op)
 		return nil
 	},
 }
 
 func findParentWithBranchLink(branchId string) string {
-	allIds, err := getAllIds()
+	ix, err := getIndex()
 	if err != nil {
 		return ""
 	}
-	linkPattern := "[[" + branchId + "]]"
-	for _, id := range allIds {
-		filePath := path.Join(zetDir, id+".md")
-		content, err := os.ReadFile(filePath)
-		if err != nil {
-			continue
-		}
-		if strings.Contains(string(content), linkPattern) {
-			return id
-		}
+	linking := ix.linkingTo(branchId)
+	if len(linking) == 0 {
+		return ""
 	}
-	return ""
+	return linking[0]
 }
 
 var ReplantCommand = cmdtree.Cmd{
 	CommandName: "replant",
 	Exec: func(args []string) error {
+		dryRun := popFlag(&args, "--dry-run", "-n")
 		if len(args) != 2 {
-			return fmt.Errorf("usage: zet2 replant <source-id-or-prefix> <new-prefix>")
+			return fmt.Errorf("usage: zet2 replant [--dry-run] <source-id-or-prefix> <new-prefix>")
 		}
 
 		sourceId := args[0]
 		newPrefix := args[1]
 
-		allIds, err := getAllIds()
-		if err != nil {
-			return fmt.Errorf("failed to get all IDs: %w", err)
-		}
-
+		var zettelsToReplant []ZettelID
 		isBranch := false
-		if !fileExists(path.Join(zetDir, sourceId+".md")) {
-			for _, id := range allIds {
-				base, _, _, err := stripLeaf(id)
-				if err != nil {
-					return fmt.Errorf("stripLeaf failed: %w", err)
-				}
-				if base == sourceId {
-					isBranch = true
-					break
-				}
-			}
-		}
-
-		var zettelsToReplant []string
-		if isBranch {
-			for _, id := range allIds {
-				base, _, _, err := stripLeaf(id)
-				if err != nil {
-					return fmt.Errorf("error splitting base ID while determining zettels to replant: %w", err)
-				}
-				if base == sourceId {
-					zettelsToReplant = append(zettelsToReplant, id)
-				}
-			}
-			if len(zettelsToReplant) == 0 {
-				return fmt.Errorf("no zettels found for branch %q", sourceId)
+		if fileExists(path.Join(zetDir, sourceId+".md")) {
+			id, err := ParseZettelID(sourceId)
+			if err != nil {
+				return fmt.Errorf("invalid source id: %w", err)
 			}
+			zettelsToReplant = append(zettelsToReplant, id)
 		} else {
-			zettelsToReplant = append(zettelsToReplant, sourceId)
-		}
-
-		sort.SliceStable(zettelsToReplant, func(i, j int) bool {
-			b1, leaf1, _, _ := stripLeaf(zettelsToReplant[i])
-			b2, leaf2, _, _ := stripLeaf(zettelsToReplant[j])
-
-			if b1 != b2 {
-				return b1 < b2
-			}
-
-			n1, err1 := strconv.Atoi(leaf1)
-			n2, err2 := strconv.Atoi(leaf2)
-
-			if err1 == nil && err2 == nil {
-				return n1 < n2
+			members, err := sequenceMembers(sourceId)
+			if err != nil {
+				return fmt.Errorf("no zettels found for branch %q: %w", sourceId, err)
 			}
-
-			return leaf1 < leaf2
-		})
+			isBranch = true
+			zettelsToReplant = members
+		}
 
 		// Check for conflicts before renaming
 		if fileExists(path.Join(zetDir, newPrefix+".md")) {
 			return fmt.Errorf("replant target prefix %q already exists", newPrefix)
 		}
 
-		for i := range zettelsToReplant {
-			var leaf string
-			if isBranch {
-				_, origLeaf, _, err := stripLeaf(zettelsToReplant[i])
-				if err != nil {
-					return fmt.Errorf("error while stripping leaf for replanting: %w", err)
-				}
-				if _, err := strconv.Atoi(origLeaf); err == nil {
-					leaf = fmt.Sprintf("%d", i+1)
-				} else {
-					leaf = fmt.Sprintf("%d%c", i+1, origLeaf[0])
-				}
-			} else {
-				leaf = "1"
-			}
-
-			newId := newPrefix + "." + leaf
-			if fileExists(path.Join(zetDir, newId+".md")) {
-				return fmt.Errorf("destination %q already exists", newId)
-			}
-		}
-
+		moves := []subtreeMove{}
 		for i, oldId := range zettelsToReplant {
-			var leaf string
-			if isBranch {
-				_, origLeaf, _, err := stripLeaf(oldId)
-				if err != nil {
-					return fmt.Errorf("error while stripping leaf for replanting: %w", err)
-				}
-				if _, err := strconv.Atoi(origLeaf); err == nil {
-					leaf = fmt.Sprintf("%d", i+1)
-				} else {
-					leaf = fmt.Sprintf("%d%c", i+1, origLeaf[0])
-				}
-			} else {
-				leaf = "1"
-			}
-
-			newId := newPrefix + "." + leaf
-
-			err := renameZettel(zetDir, oldId, newId, &[]string{})
-			if err != nil {
-				return fmt.Errorf("failed to rename %q: %w", oldId, err)
+			newId, err := ParseZettelID(fmt.Sprintf("%s.%d", newPrefix, i+1))
+			if err != nil || newId.SequenceKey() != newPrefix {
+				return fmt.Errorf("invalid replant target prefix %q", newPrefix)
 			}
+			moves = append(moves, subtreeMove{From: oldId, To: newId})
 		}
 
-		parentWithLink := findParentWithBranchLink(sourceId)
-		if parentWithLink != "" && isBranch {
-			firstNewId := fmt.Sprintf("%s.1", newPrefix)
-			oldBranchLink := "[[" + sourceId + "]]"
-			newLink := "[[" + firstNewId + "]]"
-			parentPath := path.Join(zetDir, parentWithLink+".md")
-			parentContent, err := os.ReadFile(parentPath)
-			if err != nil {
-				return fmt.Errorf("")
-			}
-
-			parentContent = []byte(strings.ReplaceAll(string(parentContent), oldBranchLink, newLink))
-			os.WriteFile(parentPath, parentContent, 0644)
-			fmt.Printf("Updated parent %s: %s -> %s\n", parentWithLink, oldBranchLink, newLink)
+		// NOTE: links to a replanted branch should point to the start of the
+		// new sequence
+		extraLinks := map[string]string{}
+		if isBranch {
+			extraLinks[sourceId] = moves[0].To.String()
 		}
 
+		cs := newChangeSet("replant", "")
+		links, err := planMoves(cs, moves, extraLinks)
+		if err != nil {
+			return fmt.Errorf("failed to plan replant: %w", err)
+		}
+		cs.op.Description = fmt.Sprintf("replant %s -> %s, %d links updated", sourceId, newPrefix, links)
+		if dryRun {
+			return previewOperation(cs)
+		}
+		err = cs.commit()
+		if err != nil {
+			return fmt.Errorf("failed to replant: %w", err)
+		}
+		reportOperation(cs.op)
 		return nil
 	},
 }
 
-// The OS function for renaming files will silently overwrite the destination
-// file, if it exists. This is not acceptable in the present case, so we wrap
-// it and check for existence first. Returning an error in case of an existing
-// file or directory in the destination path.
-func performRename(src, dst string) error {
-	if fileExists(dst) {
-		return fmt.Errorf("destination file %q exists", dst)
-	}
-	return os.Rename(src, dst)
-}
+// errNotFound is wrapped by the errors of lookups that came up empty, to tell
+// them from those that failed.
+var errNotFound = errors.New("not found")
 
 func resolveSentinelZet(prefix string, start bool) (string, error) {
-	entries, err := os.ReadDir(zetDir)
+	members, err := sequenceMembers(prefix)
 	if err != nil {
-		return "", fmt.Errorf("Unable to read zettel dir %q: %w", zetDir, err)
+		return "", fmt.Errorf("Unable to find sequence: %w", err)
 	}
-	var num int
-	var dotSeparated bool
 	if start {
-		num, dotSeparated, err = findLowestNumInSeq(prefix, entries)
+		return members[0].String(), nil
+	}
+	return members[len(members)-1].String(), nil
+}
+
+// sequenceMembers returns the zettels in the sequence identified by the given
+// key, in folgezettel order. The key is either a prefix, for top level
+// sequences, or a branch ID. Returns error if the sequence has no members.
+func sequenceMembers(key string) ([]ZettelID, error) {
+	allIds, err := getAllIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving all ids: %w", err)
+	}
+	return membersIn(allIds, key)
+}
+
+// membersIn does the work of sequenceMembers on the given list of IDs.
+func membersIn(allIds []string, key string) ([]ZettelID, error) {
+	members := []ZettelID{}
+	for _, e := range allIds {
+		id, err := ParseZettelID(e)
 		if err != nil {
-			return "", fmt.Errorf("Unable to find earliest number in sequence: %w", err)
+			continue // NOTE: not a zettel, not our concern
 		}
-	} else {
-		num, dotSeparated, err = findHighestNumInSeq(prefix, entries)
-		if err != nil {
-			return "", fmt.Errorf("Unable to find latest number in sequence: %w", err)
+		if id.SequenceKey() == key {
+			members = append(members, id)
 		}
 	}
-	var zettelId string
-	if dotSeparated {
-		zettelId = fmt.Sprintf("%s.%d", prefix, num)
-	} else {
-		zettelId = fmt.Sprintf("%s%d", prefix, num)
+	if len(members) == 0 {
+		return nil, fmt.Errorf("sequence %q %w", key, errNotFound)
 	}
-	return zettelId, nil
+	slices.SortFunc(members, ZettelID.Compare)
+	return members, nil
+}
+
+// branchesOf returns the IDs of all branches off the given zettel that have at
+// least one zettel in them.
+func branchesOf(parent ZettelID) ([]ZettelID, error) {
+	allIds, err := getAllIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving all ids: %w", err)
+	}
+	return branchesIn(allIds, parent), nil
+}
+
+// branchesIn does the work of branchesOf on the given list of IDs.
+func branchesIn(allIds []string, parent ZettelID) []ZettelID {
+	seen := map[string]bool{}
+	ret := []ZettelID{}
+	for _, e := range allIds {
+		id, err := ParseZettelID(e)
+		if err != nil || id.IsTopLevel() {
+			continue
+		}
+		branch, err := id.Branch()
+		if err != nil {
+			continue
+		}
+		p, err := branch.Parent()
+		if err != nil || p.String() != parent.String() || seen[branch.String()] {
+			continue
+		}
+		seen[branch.String()] = true
+		ret = append(ret, branch)
+	}
+	slices.SortFunc(ret, ZettelID.Compare)
+	return ret
 }
 
 var LeafCommand = cmdtree.Cmd{
@@ -918,30 +961,34 @@ var ResolveCommand = cmdtree.Cmd{
 		if err != nil {
 			return fmt.Errorf("failed to shift id off args in resolve command: %w", err)
 		}
-		resolved := id
-		if !unicode.IsDigit(rune(id[len(id)-1])) {
-			resolved, err = resolveSentinelZet(id, true)
-			if err != nil {
-				return fmt.Errorf("error while resolving sentinel zet for normal resolve command")
-			}
-		}
-
-		filePath := path.Join(zetDir, resolved+".md")
-		if !fileExists(filePath) {
-			resolved, err = resolveSentinelZet(id, true)
-			if err != nil {
-				return fmt.Errorf("error while resolving sentinel zet after determinin file didn't exist: %w", err)
-			}
-			filePath = path.Join(zetDir, resolved+".md")
-			if !fileExists(filePath) {
-				return fmt.Errorf("file does not exist: %q", filePath)
-			}
+		_, filePath, err := resolveZettel(id)
+		if err != nil {
+			return err
 		}
 		fmt.Println(filePath)
 		return nil
 	},
 }
 
+// resolveZettel resolves a zettel, branch or prefix to a zettel, being the
+// first member for branches and prefixes.
+func resolveZettel(id string) (resolvedId string, filePath string, err error) {
+	filePath = path.Join(zetDir, id+".md")
+	if fileExists(filePath) {
+		return id, filePath, nil
+	}
+	// NOTE: not a zettel, so must be a branch or a prefix
+	resolved, err := resolveSentinelZet(id, true)
+	if err != nil {
+		return "", "", fmt.Errorf("error while resolving sentinel zet after determinin file didn't exist: %w", err)
+	}
+	filePath = path.Join(zetDir, resolved+".md")
+	if !fileExists(filePath) {
+		return "", "", fmt.Errorf("file %q %w", filePath, errNotFound)
+	}
+	return resolved, filePath, nil
+}
+
 // getIdFromPathOnArgs shifts os.Args and returns the zettel id of the file
 // path that is assumed to be the first argument
 func getIdFromPathOnArgs(args *[]string) (string, error) {
@@ -957,125 +1004,123 @@ func getIdFromPathOnArgs(args *[]string) (string, error) {
 	return id, nil
 }
 
-// alphaMax takes two alphabetic strings and returns the one with the highest
-// lexical value. Returns error if the strings are equal.
-func alphaMax(a, b string) (string, error) {
-	if len(b) < len(a) {
-		return a, nil
-	}
-	if len(a) < len(b) {
-		return b, nil
-	}
-	for i := 0; i < len(a); i++ {
-		if a[i] > b[i] {
-			return a, nil
+// createZettel creates the next zettel in the sequence with the given prefix,
+// returning its ID, path and the line to put the cursor on.
+func createZettel(prefix, title string) (string, string, int, error) {
+	// NOTE: check against reserved stuff
+	for _, e := range reservedPrefixes {
+		if e == prefix {
+			return "", "", 0, fmt.Errorf("reserved prefix")
 		}
-		if a[i] < b[i] {
-			return b, nil
+	}
+
+	var zettelId string
+	members, err := sequenceMembers(prefix)
+	if err != nil {
+		// NOTE: first zettel with given prefix
+		zettelId = fmt.Sprintf("%s.%d", prefix, 1)
+	} else {
+		next, err := members[len(members)-1].Next()
+		if err != nil {
+			return "", "", 0, fmt.Errorf("unable to determine next zettel in sequence %q: %w", prefix, err)
 		}
+		zettelId = next.String()
 	}
-	return "", fmt.Errorf("%q and %q seem to be equal", a, b)
+
+	id, err := ParseZettelID(zettelId)
+	if err != nil || id.SequenceKey() != prefix {
+		return "", "", 0, fmt.Errorf("invalid prefix %q", prefix)
+	}
+
+	filePath, cursor, err := createZettelFile(zettelId, title)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("error while creating file while creating new zettel: %w", err)
+	}
+	return zettelId, filePath, cursor, nil
 }
 
-func createZettelFile(zettelId string) (string, error) {
+// createZettelFile creates the file of a new zettel from its template,
+// returning its path and the line to put the cursor on.
+func createZettelFile(zettelId, title string) (string, int, error) {
 	fileName := fmt.Sprintf("%s.md", zettelId)
 	filePath := path.Join(zetDir, fileName)
 	if fileExists(filePath) {
-		return "", fmt.Errorf("attempted to create existing file: %s", filePath)
+		return "", 0, fmt.Errorf("attempted to create existing file: %s", filePath)
+	}
+	content, cursor, err := newZettelContent("create", zettelId, title, readZettelFile)
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to make content of new zettel: %w", err)
 	}
+
 	f, err := os.Create(filePath)
 	if err != nil {
-		return "", fmt.Errorf("unable to create file %q: %w", filePath, err)
+		return "", 0, fmt.Errorf("unable to create file %q: %w", filePath, err)
 	}
 	defer f.Close()
 
-	ts := timestamp()
-	content := fmt.Sprintf("---\nzettel: %s\ndate: %s\n---\n\n\n\n", zettelId, ts)
 	_, err = f.Write([]byte(content))
 	if err != nil {
-		return "", fmt.Errorf("failed to write content to new zettel file: %w", err)
+		return "", 0, fmt.Errorf("failed to write content to new zettel file: %w", err)
 	}
-	return filePath, nil
+	return filePath, cursor, nil
 }
 
-func getIdsMatchingPrefix(prefix string) ([]string, error) {
-	allIds, err := getAllIds()
+// skipResolve finds the closest existing zettel after the given one in its
+// sequence, or before it if reverse is set, skipping over any gaps.
+func skipResolve(id ZettelID, reverse bool) (nextId, nextPath string, err error) {
+	members, err := sequenceMembers(id.SequenceKey())
 	if err != nil {
-		return nil, fmt.Errorf("failed retrieving all ids: %w", err)
-	}
-	idsMatchingPrefix := []string{}
-	for _, e := range allIds {
-		if strings.HasPrefix(e, prefix) {
-			idsMatchingPrefix = append(idsMatchingPrefix, e)
-		}
-	}
-	return idsMatchingPrefix, nil
-}
-
-func skipResolve(base string, seqNum int, reverse bool) (nextId, nextPath string, err error) {
-	allMatchingIds, err := getIdsMatchingPrefix(base)
-	if err != nil {
-		err = fmt.Errorf("failed to get ids matching prefix %q: %w", base, err)
+		err = fmt.Errorf("failed to get members of sequence %q: %w", id.SequenceKey(), err)
 		return
 	}
-	reverseMaxMatch := -1
-	for _, id := range allMatchingIds {
-		subBase, subSeq, _, err := stripLeaf(id)
-		if err != nil {
-			panic(err) // TODO: figure out what to do here
-		}
-		if subBase == base {
-			subSeqNum, err := strconv.Atoi(subSeq)
-			if err != nil {
-				return nextId, nextPath, err
-			}
-			if !reverse {
-				if subSeqNum > seqNum {
-					nextId = fmt.Sprintf("%s%d", base, subSeqNum)
-					nextPath = path.Join(zetDir, nextId+".md")
-					return nextId, nextPath, err
-				}
-			} else {
-				if (subSeqNum < seqNum) && (reverseMaxMatch < subSeqNum) {
-					reverseMaxMatch = subSeqNum
-				}
-			}
-		}
-
-	}
 	if reverse {
-		if reverseMaxMatch != -1 {
-			nextId = fmt.Sprintf("%s%d", base, reverseMaxMatch)
+		slices.Reverse(members)
+	}
+	for _, m := range members {
+		c := m.Compare(id)
+		if (!reverse && c > 0) || (reverse && c < 0) {
+			nextId = m.String()
 			nextPath = path.Join(zetDir, nextId+".md")
-			return nextId, nextPath, err
+			return nextId, nextPath, nil
 		}
 	}
 
-	return nextId, nextPath, fmt.Errorf("no available zettel to skip to")
+	return nextId, nextPath, fmt.Errorf("zettel to skip to %w", errNotFound)
+}
+
+// parseIdArg parses a zettel ID given as an argument on the command line,
+// hinting at the path subcommands if it looks like a file path was given.
+func parseIdArg(s string) (ZettelID, error) {
+	id, err := ParseZettelID(s)
+	if err != nil && strings.ContainsAny(s, "/\\") {
+		return id, fmt.Errorf("%w. Did you mean to call the 'path' subcommand?", err)
+	}
+	if err == nil && id.IsBranch() {
+		return id, fmt.Errorf("%q is a branch, not a zettel", s)
+	}
+	return id, err
 }
 
 func determineNextZet(id string) (nextId string, nextPath string, err error) {
-	base, seq, _, err := stripLeaf(id)
+	zid, err := parseIdArg(id)
 	if err != nil {
 		return nextId, nextPath, err
 	}
 
-	seqNum, err := strconv.Atoi(seq)
+	next, err := zid.Next()
 	if err != nil {
 		return nextId, nextPath, err
 	}
-
-	nextId = fmt.Sprintf("%s%d", base, seqNum+1)
+	nextId = next.String()
 	nextPath = path.Join(zetDir, nextId+".md")
 
 	if !fileExists(nextPath) {
-		nextId, nextPath, err = skipResolve(base, seqNum, false)
+		missingPath := nextPath
+		nextId, nextPath, err = skipResolve(zid, false)
+		if errors.Is(err, errNotFound) {
+			err = fmt.Errorf("next file %q %w", missingPath, errNotFound)
+		}
 		if err != nil {
-			if strings.Contains(nextPath, "/") || strings.Contains(nextPath, "\\") {
-				err = fmt.Errorf("next file %q doesn't exist. Did you mean to call the 'next path' subcommand?", nextPath)
-			} else {
-				err = fmt.Errorf("next file %q doesn't exist", nextPath)
-			}
 			return nextId, nextPath, err
 		}
 	}
@@ -1083,81 +1128,72 @@ func determineNextZet(id string) (nextId string, nextPath string, err error) {
 }
 
 func determinePrevZet(id string) (prevId string, prevPath string, err error) {
-	base, seq, _, err := stripLeaf(id)
-	if err != nil {
-		return prevId, prevPath, err
-	}
-
-	seqNum, err := strconv.Atoi(seq)
+	zid, err := parseIdArg(id)
 	if err != nil {
 		return prevId, prevPath, err
 	}
 
-	prevNum := seqNum - 1
-	if prevNum < 1 {
-		prevId = fmt.Sprintf("%s%d", base, prevNum)
+	prev, err := zid.Prev()
+	if err == nil {
+		prevId = prev.String()
 		prevPath = path.Join(zetDir, prevId+".md")
-		if !fileExists(prevPath) {
-
-			prevId = fmt.Sprintf("%s%d", base, 0)
-			base, _, isDigit, err := stripLeaf(prevId)
-			if err != nil {
-				return prevId, prevPath, err
-			}
-			if !isDigit {
-				return "", "", fmt.Errorf("branch seems to be numeric. Cannot resolve parent")
-			}
-			base, seq, isDigit, err = stripLeaf(base)
-			if err != nil {
-				return prevId, prevPath, err
-			}
-			if isDigit {
-				return "", "", fmt.Errorf("branch seems to be numeric despite leaf being numeric, cannot resolve parent")
-			}
-			prevId = base
-			prevPath = path.Join(zetDir, prevId+".md")
-			if !fileExists(prevPath) {
-				err = fmt.Errorf("previous file %q doesn't exist", prevPath)
-				return prevId, prevPath, err
-			}
+		if fileExists(prevPath) {
+			return prevId, prevPath, nil
 		}
+	}
+
+	prevId, prevPath, err = skipResolve(zid, true)
+	if err == nil {
 		return prevId, prevPath, nil
 	}
 
-	prevId = fmt.Sprintf("%s%d", base, prevNum)
+	// NOTE: first in its sequence, so the previous zettel is the one the
+	// branch grows from
+	parent, err := zid.Parent()
+	if err != nil {
+		return "", "", fmt.Errorf("zettel preceding %q %w: %w", id, errNotFound, err)
+	}
+	prevId = parent.String()
 	prevPath = path.Join(zetDir, prevId+".md")
 	if !fileExists(prevPath) {
-		prevId, prevPath, err = skipResolve(base, seqNum, true)
-		if err != nil {
-			//TODO: move this path-check earlier in tree to give helpful error messages in this case no matter the input id
-			if strings.Contains(prevPath, "/") || strings.Contains(prevPath, "\\") {
-				err = fmt.Errorf("previous file %q doesn't exist. Did you mean to call the 'previous path' subcommand?", prevPath)
-			} else {
-				err = fmt.Errorf("previous file %q doesn't exist", prevPath)
-			}
-			return prevId, prevPath, err
-		}
+		err = fmt.Errorf("previous file %q %w", prevPath, errNotFound)
+		return prevId, prevPath, err
 	}
-
 	return prevId, prevPath, nil
 }
 
+var linkRegex = regexp.MustCompile(`\[\[(?P<link>[a-zA-Z0-9\.\-\_]+)\]\]`)
+
 // extractLinksFromContent takes the entire content of a zettel and extracts
 // all links from it, stripping them of markup, leaving only the linked zettel
 // IDs as a string slice.
 func extractLinksFromContent(content string) []string {
 	var links []string
-	r := regexp.MustCompile(`\[\[(?P<link>[a-zA-Z0-9\.\-\_]+)\]\]`)
 	for line := range strings.SplitSeq(content, "\n") {
-		match := r.FindStringSubmatch(line)
-		if len(match) < 2 {
-			continue
+		for _, match := range linkRegex.FindAllStringSubmatch(line, -1) {
+			links = append(links, match[1])
 		}
-		links = append(links, match[1])
 	}
 	return links
 }
 
+// rewriteLinks replaces the target of every link in the content for which
+// retarget returns a new one, returning the new content and the number of
+// links that were replaced.
+func rewriteLinks(content string, retarget func(string) (string, bool)) (string, int) {
+	n := 0
+	ret := linkRegex.ReplaceAllStringFunc(content, func(link string) string {
+		target := link[2 : len(link)-2]
+		newTarget, ok := retarget(target)
+		if !ok || newTarget == target {
+			return link
+		}
+		n++
+		return "[[" + newTarget + "]]"
+	})
+	return ret, n
+}
+
 func fileExists(filePath string) bool {
 	_, err := os.Stat(filePath)
 	return err == nil || !os.IsNotExist(err)
@@ -1165,154 +1201,93 @@ func fileExists(filePath string) bool {
 
 // filterBranches takes a slice of links (as stripped zettel IDs) and a zettel
 // ID, and filters out all links that are not direct branches of the zettel ID.
-func filterBranches(links []string, parentId string) ([]string, error) {
+// Links that are not valid zettel IDs are ignored.
+func filterBranches(links []string, parent ZettelID) []ZettelID {
 	// NOTE: Branches are always alphabetically suffixed. links to specific
 	// zettels in a branch have the sequence number
-	var branches []string
+	var branches []ZettelID
 	for _, l := range links {
-		base, _, digit, err := stripLeaf(l)
-		if err != nil {
-			return nil, fmt.Errorf("error stripping leaf while filtering branches: %w", err)
+		id, err := ParseZettelID(l)
+		if err != nil || !id.IsBranch() {
+			continue
 		}
-		if digit {
+		p, err := id.Parent()
+		if err != nil {
 			continue
 		}
-		if base == parentId {
-			branches = append(branches, l)
+		if p.String() == parent.String() {
+			branches = append(branches, id)
 		}
 	}
-	return branches, nil
+	return branches
 }
 
 func getAllIds() ([]string, error) {
-	entries, err := os.ReadDir(zetDir)
+	ix, err := getIndex()
 	if err != nil {
-		return nil, fmt.Errorf("unable to read zettel dir %q: %w", zetDir, err)
-	}
-	ret := []string{}
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
-		}
-		id, found := strings.CutSuffix(e.Name(), ".md")
-		if !found {
-			continue
-		}
-		ret = append(ret, id)
+		return nil, err
 	}
-	return ret, nil
+	return ix.ids(), nil
 }
 
 func getFirstSeqInBranch(id string) (string, error) {
-	maxSeqVal := sequenceUpperLimit
-	allIds, err := getAllIds()
+	members, err := sequenceMembers(id)
 	if err != nil {
-		return "", fmt.Errorf("failed retrieving all ids: %w", err)
-	}
-	minSeq := maxSeqVal
-	for _, e := range allIds {
-		if strings.HasPrefix(e, id) {
-			base, seq, _, err := stripLeaf(e)
-			if err != nil {
-				return "", fmt.Errorf("failed to strip leaf of zettel ID: %w", err)
-			}
-			if base != id {
-				continue
-			}
-			n, err := strconv.Atoi(seq)
-			if err != nil {
-				return "", fmt.Errorf("failed converting sequence to number: %w", err)
-			}
-
-			if n == maxSeqVal {
-				panic("encountered unexpectedly large sequence, logic should be revisited")
-			}
-
-			if n == 0 {
-				minSeq = n
-				break // cant go lower, stop search
-			}
-			if n < minSeq {
-				minSeq = n
-			}
-		}
-	}
-	if minSeq == maxSeqVal {
 		return "", fmt.Errorf("Unable to find branch: %q", id)
 	}
-
-	return id + strconv.Itoa(minSeq), nil
+	return members[0].String(), nil
 }
 
-// incrementAlphaBranch takes a zettel ID that ends in an alphabetic character
-// and returns its zettelkasten-ID increment. E.g: tmp.1a -> tmp.1b. Returns
-// error on invalid input.
-func incrementAlphaBranch(id string) (string, error) {
-	var alphabet = "abcdefghijklmnopqrstuvwxyz"
-
-	if id[len(id)-1:] == "z" {
-		return id + "a", nil // z -> za
-	}
-
-	for i, r := range alphabet {
-		if string(r) == id[len(id)-1:] {
-			return id[:len(id)-1] + string(alphabet[i+1]), nil
-		}
-	}
-
-	return id, fmt.Errorf("invalid branch string")
-}
-
-// nextBranch takes a list of sibling zettel IDs and returns the ID of the next
-// upcoming branch on the parent zettel, as well as an error. In the case of an
-// empty slice (no other children of current zettel), returns an 'a'.
-func nextBranch(branches []string) (string, error) {
+// nextBranch takes a parent zettel and a list of its branches, and returns the
+// ID of the next upcoming branch on the parent zettel, as well as an error. In
+// the case of an empty slice (no other children of current zettel), returns
+// the 'a' branch.
+func nextBranch(parent ZettelID, branches []ZettelID) (ZettelID, error) {
 
 	// the first branch will always be 'a' in a numbered sceme
 	if len(branches) == 0 {
-		return "a", nil
+		return parent.Child("a")
 	}
 
-	var err error
-
-	var isDigits bool
-	maxChars := ""
+	maxBranch := branches[0]
 	for _, branch := range branches {
-		_, branch, isDigits, err = stripLeaf(branch)
-		if err != nil {
-			return "", fmt.Errorf("unable to strip branch leaf: %w", err)
+		if !branch.IsBranch() {
+			return ZettelID{}, fmt.Errorf("%q is not a branch", branch)
 		}
-
-		// TODO: clean this up
-		if isDigits {
-			panic("Attempting to process branch that ends in a number. This is no longer applicable, and there must have happened a programming error. This is a bug.")
-		} else {
-			maxChars, err = alphaMax(maxChars, branch)
-			if err != nil {
-				return "", fmt.Errorf("failed to calculate alphaMax while computing next branch: %w", err)
-			}
+		if branch.Compare(maxBranch) > 0 {
+			maxBranch = branch
 		}
 	}
 
-	if maxChars == "" {
-		panic("highest branch number not detected after iterating through all given branches in nextBranch")
-	}
-
-	nextAlphaBranch, err := incrementAlphaBranch(maxChars)
+	next, err := maxBranch.Next()
 	if err != nil {
-		return "", fmt.Errorf("unable to increment leaf: %w", err)
+		return ZettelID{}, fmt.Errorf("unable to increment leaf: %w", err)
 	}
-	return nextAlphaBranch, nil
+	return next, nil
 }
 
+// openInEditor opens a zettel in the editor, with the cursor at the start of
+// its body.
 func openInEditor(path string, insertMode bool) error {
-	var cmd *exec.Cmd
-	if (editor == "vim" || editor == "nvim") && insertMode {
-		cmd = exec.Command(editor, "+6", "-c", "startinsert", path)
-	} else {
-		cmd = exec.Command(editor, "+6", path)
+	line := 1
+	if buf, err := os.ReadFile(path); err == nil {
+		line = bodyLine(string(buf))
+	}
+	return openInEditorAt(path, line, insertMode)
+}
+
+func openInEditorAt(path string, line int, insertMode bool) error {
+	argv := editorCommand(path, line)
+	if len(argv) == 0 {
+		return fmt.Errorf("no editor configured, set $EDITOR or the 'editor' config key")
 	}
 
+	base := argv[0][strings.LastIndexAny(argv[0], "/\\")+1:]
+	if (base == "vim" || base == "nvim") && insertMode {
+		argv = append([]string{argv[0], "-c", "startinsert"}, argv[1:]...)
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
@@ -1320,6 +1295,27 @@ func openInEditor(path string, insertMode bool) error {
 	return cmd.Run()
 }
 
+// editorCommand expands the editor command template into an argument list for
+// opening the given file at the given line. A bare command, like the usual
+// value of $EDITOR, is taken to mean '<command> +{{line}} {{path}}'.
+func editorCommand(path string, line int) []string {
+	template := editor
+	if !strings.Contains(template, "{{") {
+		template += " +{{line}} {{path}}"
+	}
+	fields := strings.Fields(template)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "{{") {
+		return nil
+	}
+	argv := []string{}
+	for _, f := range fields {
+		f = strings.ReplaceAll(f, "{{path}}", path)
+		f = strings.ReplaceAll(f, "{{line}}", strconv.Itoa(line))
+		argv = append(argv, f)
+	}
+	return argv
+}
+
 func putOnClipboard(text string) error {
 	var cmd *exec.Cmd
 	switch runtime.GOOS {
@@ -1342,281 +1338,241 @@ func putOnClipboard(text string) error {
 	return cmd.Run()
 }
 
-// stripLeaf takes a zettel ID and strips the leaf branch off it, splitting the
-// ID into its parent and child components.
-//
-// E.g: tmp.12.321aa32c69 -> 'tmp.12.321aa32c', '69'
-//
-// Returns base, branch, a boolean stating if the stripped leaf was numeric or
-// not, and potentially an error.
-func stripLeaf(id string) (string, string, bool, error) {
-	var base string
-	var branch string
-	var isDigits bool
-	var err error
-
-	runes := []rune(id)
-	lastRune := runes[len(runes)-1]
-	isDigits = unicode.IsDigit(lastRune)
-
-	i := -2
-	for unicode.IsDigit(runes[len(runes)+i]) == isDigits && i+len(runes) > 0 {
-		i -= 1
-	}
-
-	base = id[:len(runes)+i+1]
-	branch = id[len(runes)+i+1:]
-
-	return base, branch, isDigits, err
-}
-
 func timestamp() string {
-	format := "Mon 2006-01-02 15:04:05 MST"
 	now := time.Now()
-	ts := now.Format(format)
+	ts := now.Format(timestampFormat)
 	return ts
 }
 
-func renameZettel(zettelDir, fromId, toId string, renamedChildren *[]string) error {
-
-	// TODO: rewrite this function to not be recursive instead. split up
-	// renaming and re-linking. This interface is UGLY. This function is
-	// inefficient as f.
-
-	if renamedChildren == nil {
-		panic("must pass renamed children slice - i know this is tech debt")
-	}
-
-	var err error
-
-	fmt.Printf("Renaming %q -> %q\n", fromId, toId)
-
-	_, _, numeric, err := stripLeaf(toId)
+// renameZettel plans renaming a zettel, along with its entire subtree, and
+// returns the change set for the caller to commit.
+func renameZettel(fromId, toId string) (*changeSet, error) {
+	from, err := ParseZettelID(fromId)
 	if err != nil {
-		return fmt.Errorf("unable to strip leaf: %w", err)
+		return nil, fmt.Errorf("invalid id to rename from: %w", err)
 	}
-
-	if !numeric {
-		return fmt.Errorf("toId refers to a branch, not a zettel")
-	}
-
-	// NOTE: validation done, rename file(s)
-	fromPath := path.Join(zettelDir, fromId+".md")
-	toPath := path.Join(zettelDir, toId+".md")
-
-	err = performRename(fromPath, toPath)
+	to, err := ParseZettelID(toId)
 	if err != nil {
-		return fmt.Errorf("failed to rename %q: %w", fromPath, err)
+		return nil, fmt.Errorf("invalid id to rename to: %w", err)
 	}
 
-	buf, err := os.ReadFile(toPath)
-	if err != nil {
-		return fmt.Errorf("failed to read file %q for updating yaml: %w", toPath, err)
+	if from.IsBranch() || to.IsBranch() {
+		return nil, fmt.Errorf("can only rename zettels, not branches")
 	}
-	updatedContent := updateYamlPreamble(string(buf), toId)
-	err = os.WriteFile(toPath, []byte(updatedContent), 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write updated yaml: %w", err)
+	if !fileExists(path.Join(zetDir, fromId+".md")) {
+		return nil, fmt.Errorf("zettel %q does not exist", fromId)
 	}
 
-	allIds, err := getAllIds()
+	cs := newChangeSet("rename", "")
+	links, err := planMoves(cs, []subtreeMove{{From: from, To: to}}, nil)
 	if err != nil {
-		return fmt.Errorf("failed retrieving all ids: %w", err)
-	}
-	oldLink := fmt.Sprintf("[[%s]]", fromId)
-	newLink := fmt.Sprintf("[[%s]]", toId)
-	for _, id := range allIds {
-		fname := path.Join(zettelDir, id+".md")
-		buf, err := os.ReadFile(fname)
-		if err != nil {
-			return fmt.Errorf("failed to read file %q to update links: %w", fname, err)
-		}
-		newContent := strings.ReplaceAll(string(buf), oldLink, newLink)
-		if newContent != string(buf) {
-			err = os.WriteFile(fname, []byte(newContent), 0644)
-			if err != nil {
-				return fmt.Errorf("failed to write file %q after updating links: %w", fname, err)
-			}
-		}
+		return nil, err
 	}
+	cs.op.Description = fmt.Sprintf("rename %s -> %s, %d links updated", fromId, toId, links)
+	return cs, nil
+}
 
-	prefix := fromId
+// subtreeMove moves a zettel or a branch to a new ID, along with everything in
+// its subtree.
+type subtreeMove struct {
+	From ZettelID
+	To   ZettelID
+}
 
-collectRenamedChildrenLoop:
-	for _, id := range allIds {
+// target returns the new ID of the given ID, if it is affected by the move.
+func (m subtreeMove) target(id ZettelID) (string, bool) {
+	if id.String() != m.From.String() && !m.From.IsAncestorOf(id) {
+		return "", false
+	}
+	tail := strings.TrimPrefix(id.String(), m.From.String())
+	return m.To.String() + tail, true
+}
 
-		for _, e := range *renamedChildren {
-			if e == id {
-				continue collectRenamedChildrenLoop
-			}
+// planMoves adds everything needed to move the given subtrees to the change
+// set: renaming the files, updating their frontmatter, and rewriting every link
+// to the moved zettels and branches across the kasten. Links to other targets
+// may be rewritten as well by giving them in extraLinks. Returns the number of
+// links that were rewritten.
+func planMoves(cs *changeSet, moves []subtreeMove, extraLinks map[string]string) (int, error) {
+	for _, m := range moves {
+		if m.From.IsBranch() != m.To.IsBranch() {
+			return 0, fmt.Errorf("cannot move %q to %q, as only one of them is a branch", m.From, m.To)
 		}
+	}
 
-		idRunes := []rune(id)
-		idLastRune := idRunes[len(idRunes)-1]
-		idIsDigit := unicode.IsDigit(idLastRune)
+	allIds, err := cs.ids()
+	if err != nil {
+		return 0, fmt.Errorf("failed retrieving all ids: %w", err)
+	}
 
+	// NOTE: determine the new ID of every zettel in the moved subtrees
+	renames := map[string]string{}
+	matched := make([]bool, len(moves))
+	for _, e := range allIds {
+		id, err := ParseZettelID(e)
 		if err != nil {
-			log.Fatalf("failed to strip leaf of id while renaming: %s", err)
+			continue
 		}
-		if tail, ok := strings.CutPrefix(id, prefix); ok {
-
-			// NOTE: if last digit of prefix and first digit of tail are both
-			// of same class, then this is not a branch boundary. E.g. tmp.1 vs
-			// tmp.123
-			tailRunes := []rune(tail)
-			tailIsDigit := unicode.IsDigit(tailRunes[0])
-			if idIsDigit == tailIsDigit {
+		for i, m := range moves {
+			newId, ok := m.target(id)
+			if !ok {
 				continue
 			}
-
-			err = renameZettel(zettelDir, id, toId+tail, renamedChildren)
-			if err != nil {
-				log.Println("successfully renamed the following before error:")
-				for _, c := range *renamedChildren {
-					log.Printf("  %s\n", c)
-				}
-				return fmt.Errorf("failed to rename %q:", err)
+			if _, dup := renames[e]; dup {
+				return 0, fmt.Errorf("%q is affected by more than one move", e)
 			}
-			*renamedChildren = append(*renamedChildren, id)
+			renames[e] = newId
+			matched[i] = true
 		}
 	}
-
-	originalContent := updatedContent
-	linkedIds := extractLinksFromContent(updatedContent)
-	for _, id := range linkedIds {
-		base, branch, isDigit, err := stripLeaf(id)
-		if err != nil {
-			return fmt.Errorf("unable to strip leaf of %q for link renaming: %w", fromId, err)
+	for i, m := range moves {
+		if !matched[i] {
+			return 0, fmt.Errorf("no zettels found at %q", m.From)
 		}
+	}
 
-		if isDigit {
-			continue
+	existing := map[string]bool{}
+	for _, e := range allIds {
+		existing[e] = true
+	}
+	taken := map[string]string{}
+	for oldId, newId := range renames {
+		if other, ok := taken[newId]; ok {
+			return 0, fmt.Errorf("both %q and %q would be moved to %q", other, oldId, newId)
 		}
-
-		if base == fromId {
-			oldLink := fmt.Sprintf("[[%s]]", id)
-			newLink := fmt.Sprintf("[[%s%s]]", toId, branch)
-			updatedContent = strings.ReplaceAll(updatedContent, oldLink, newLink)
+		taken[newId] = oldId
+		if _, err := ParseZettelID(newId); err != nil {
+			return 0, fmt.Errorf("%q would be moved to invalid id %q", oldId, newId)
 		}
+		if _, moving := renames[newId]; existing[newId] && !moving {
+			return 0, fmt.Errorf("destination %q already exists", newId)
+		}
+	}
+
+	// NOTE: all content is read before anything is moved, since destinations
+	// may be sources of other moves
+	oldIds := []string{}
+	for oldId := range renames {
+		oldIds = append(oldIds, oldId)
 	}
-	if updatedContent != originalContent {
-		err = os.WriteFile(toPath, []byte(updatedContent), 0644)
+	sort.Strings(oldIds)
+	contents := map[string]string{}
+	for _, oldId := range oldIds {
+		content, _, err := cs.read(oldId + ".md")
 		if err != nil {
-			return fmt.Errorf("failed to write updated branch links to %q: %w", toPath, err)
+			return 0, fmt.Errorf("failed to read %q for moving: %w", oldId, err)
 		}
+		contents[oldId] = content
 	}
-
-	allIds, err = getAllIds()
-	if err != nil {
-		return fmt.Errorf("failed retrieving all ids: %w", err)
+	for _, oldId := range oldIds {
+		err := cs.remove(oldId + ".md")
+		if err != nil {
+			return 0, err
+		}
 	}
-	for _, root := range allIds {
-		thePath := path.Join(zettelDir, root+".md")
-		buf, err := os.ReadFile(thePath)
+	for _, oldId := range oldIds {
+		newId := renames[oldId]
+		err := cs.write(newId+".md", updateYamlPreamble(contents[oldId], newId))
 		if err != nil {
-			return fmt.Errorf("failed to read file %q for updating branch links: %w", toPath, err)
+			return 0, err
 		}
-		content := string(buf)
-
-		linkedIds := extractLinksFromContent(content)
-		for _, id := range linkedIds {
-			base, branch, isDigit, err := stripLeaf(id)
-			if err != nil {
-				return fmt.Errorf("unable to strip leaf of %q for link renaming: %w", fromId, err)
-			}
-
-			if isDigit {
-				continue
-			}
+		cs.op.Moves = append(cs.op.Moves, fileMove{From: oldId + ".md", To: newId + ".md"})
+	}
 
-			if base == fromId {
-				oldLink := fmt.Sprintf("[[%s]]", id)
-				newLink := fmt.Sprintf("[[%s%s]]", toId, branch)
-				content = strings.ReplaceAll(content, oldLink, newLink)
-			}
+	retarget := func(link string) (string, bool) {
+		if t, ok := extraLinks[link]; ok {
+			return t, true
 		}
-
-		if string(buf) != content {
-			err = os.WriteFile(thePath, []byte(content), 0644)
-			if err != nil {
-				return fmt.Errorf("failed to write updated branch links to %q: %w", toPath, err)
+		id, err := ParseZettelID(link)
+		if err != nil {
+			return "", false
+		}
+		for _, m := range moves {
+			if t, ok := m.target(id); ok {
+				return t, true
 			}
 		}
+		return "", false
 	}
 
-	fmt.Printf("Finished renaming %q -> %q\n", fromId, toId)
-
-	return nil
-}
-
-func updateYamlPreamble(content, newId string) string {
-
-	lines := strings.Split(content, "\n")
-	preableStarted := false
-	preableEnded := false
-	noPreamble := true
-	zettelKeyFound := false
-
-	tmpPreamble := []string{}
-	tmpContent := []string{}
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-
-		if !preableStarted && trimmed != "---" && trimmed != "" {
-			break
+	// NOTE: only files known to link to an affected target need rewriting,
+	// along with any file already changed in this set
+	ix, err := getIndex()
+	if err != nil {
+		return 0, fmt.Errorf("failed retrieving index: %w", err)
+	}
+	candidates := map[string]bool{}
+	for target, sources := range ix.Backlinks {
+		if _, ok := retarget(target); !ok {
+			continue
 		}
-
-		if preableStarted && trimmed == "---" {
-			preableEnded = true
-			if !zettelKeyFound {
-				tmpPreamble = append(tmpPreamble, fmt.Sprintf("zettel: %s", newId))
+		for _, s := range sources {
+			if newId, moved := renames[s]; moved {
+				s = newId
 			}
-			continue
+			candidates[s+".md"] = true
 		}
+	}
+	for name, c := range cs.files {
+		if c.After != nil && strings.HasSuffix(name, ".md") {
+			candidates[name] = true
+		}
+	}
+	names := []string{}
+	for name := range candidates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		if !preableStarted && trimmed == "---" {
-			preableStarted = true
-			noPreamble = false
+	updated := 0
+	for _, name := range names {
+		content, exists, err := cs.read(name)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %q to update links: %w", name, err)
+		}
+		if !exists {
 			continue
 		}
-
-		if preableStarted && !preableEnded {
-			if strings.HasPrefix(trimmed, "zettel:") {
-				newLine := fmt.Sprintf("zettel: %s", newId)
-				tmpPreamble = append(tmpPreamble, newLine)
-				zettelKeyFound = true
-			} else {
-				tmpPreamble = append(tmpPreamble, trimmed)
-			}
+		newContent, n := rewriteLinks(content, retarget)
+		if n == 0 {
+			continue
 		}
-
-		if preableEnded {
-			tmpContent = append(tmpContent, line)
+		updated += n
+		err = cs.write(name, newContent)
+		if err != nil {
+			return 0, err
 		}
 	}
+	return updated, nil
+}
 
-	if noPreamble {
-		newContent := fmt.Sprintf("---\nzettel: %s\n---\n\n%s", newId, content)
-		return newContent
+// reportOperation prints a summary of a completed operation.
+func reportOperation(op operation) {
+	for _, m := range op.Moves {
+		from, _ := strings.CutSuffix(m.From, ".md")
+		to, _ := strings.CutSuffix(m.To, ".md")
+		fmt.Printf("Renamed %q -> %q\n", from, to)
 	}
+	fmt.Println(op.Description)
+}
 
-	newPreamble := strings.Join(tmpPreamble, "\n")
-	newContent := strings.Join(tmpContent, "\n")
-	newContent = fmt.Sprintf("---\n%s\n---\n%s", newPreamble, newContent)
-
-	return newContent
+// previewOperation prints what a planned operation would do, without doing it.
+func previewOperation(cs *changeSet) error {
+	cs.preview(os.Stdout)
+	fmt.Println()
+	for _, m := range cs.op.Moves {
+		from, _ := strings.CutSuffix(m.From, ".md")
+		to, _ := strings.CutSuffix(m.To, ".md")
+		fmt.Printf("Would rename %q -> %q\n", from, to)
+	}
+	fmt.Printf("Dry run, nothing was changed: %s, %d files affected\n", cs.op.Description, len(cs.changes()))
+	return nil
 }
 
 // 0.7 here
 
-// TODO: extract command
-//	- must update subtree IDs == rename command is needed
-//	- furthermore, must effectively graft any child branches in selection onto new note
-
 // 0.8 here
 
-// TODO: add persistent configuration of default prefix
-
 // 0.9 here
 
 // TODO: code cleanup/refactoring
@@ -1632,10 +1588,4 @@ func updateYamlPreamble(content, newId string) string {
 // further features past 1.0
 // TODO: resolve branch subcommand that returns branch prefix of given id or
 // path: tmp.1asdf32 -> tmp.1asdf || .../tmp.1asdf32.md -> tmp.1asdf
-// TODO: graft command
-// TODO: prune command
-// TODO: browse command - TUI
-//	- look at gh for rendering markdown
-//	- look at logbrowser for the tui stuff, dont overcomplicate
-// TODO: more sophisticated search
 // TODO: windows support for link command
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A small markdown renderer for the subset of markdown that zettels are
+// written in: headings, paragraphs, lists, block quotes, fenced code, rules,
+// and inline code, emphasis, links and wiki links. Anything else is rendered
+// as text, so nothing in a zettel is ever lost, only left unformatted.
+
+// linkResolver turns the target of a wiki link into a URL, reporting false if
+// the target can't be linked to.
+type linkResolver func(target string) (string, bool)
+
+var (
+	orderedItemRegex = regexp.MustCompile(`^(\d+)[.)]\s+`)
+	mdLinkRegex      = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	strongRegex      = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	emRegex          = regexp.MustCompile(`\*([^*\s][^*]*)\*|\b_([^_\s][^_]*)_\b`)
+	placeholderRegex = regexp.MustCompile("\x00([0-9]+)\x00")
+)
+
+// renderMarkdown renders the body of a zettel as HTML.
+func renderMarkdown(body string, resolve linkResolver) string {
+	var sb strings.Builder
+	renderBlocks(&sb, strings.Split(body, "\n"), resolve)
+	return sb.String()
+}
+
+// listItemText returns the text of a list item line, and whether the list is
+// ordered, or false if the line isn't a list item.
+func listItemText(line string) (string, bool, bool) {
+	for _, bullet := range []string{"- ", "* ", "+ "} {
+		if text, ok := strings.CutPrefix(line, bullet); ok {
+			return text, false, true
+		}
+	}
+	if m := orderedItemRegex.FindString(line); m != "" {
+		return line[len(m):], true, true
+	}
+	return "", false, false
+}
+
+func isRule(line string) bool {
+	trimmed := strings.ReplaceAll(line, " ", "")
+	if len(trimmed) < 3 {
+		return false
+	}
+	return strings.Count(trimmed, trimmed[:1]) == len(trimmed) && strings.Contains("-*_", trimmed[:1])
+}
+
+func renderBlocks(sb *strings.Builder, lines []string, resolve linkResolver) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case strings.HasPrefix(trimmed, "```"):
+			lang := strings.TrimSpace(strings.TrimLeft(trimmed, "`"))
+			code := []string{}
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			i++ // NOTE: the closing fence
+			if lang != "" {
+				fmt.Fprintf(sb, "<pre><code class=\"language-%s\">", html.EscapeString(lang))
+			} else {
+				sb.WriteString("<pre><code>")
+			}
+			sb.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			sb.WriteString("</code></pre>\n")
+
+		case isHeading(trimmed):
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			level = min(level, 6)
+			text := strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			fmt.Fprintf(sb, "<h%d>%s</h%d>\n", level, renderInline(text, resolve), level)
+			i++
+
+		case isRule(trimmed):
+			sb.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			quoted := []string{}
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(q, " "))
+			}
+			sb.WriteString("<blockquote>\n")
+			renderBlocks(sb, quoted, resolve)
+			sb.WriteString("</blockquote>\n")
+
+		default:
+			if _, ordered, ok := listItemText(trimmed); ok {
+				i = renderList(sb, lines, i, ordered, resolve)
+				continue
+			}
+			paragraph := []string{}
+			for ; i < len(lines); i++ {
+				t := strings.TrimSpace(lines[i])
+				if _, _, item := listItemText(t); t == "" || item || isHeading(t) ||
+					strings.HasPrefix(t, "```") || strings.HasPrefix(t, ">") {
+					break
+				}
+				paragraph = append(paragraph, t)
+			}
+			fmt.Fprintf(sb, "<p>%s</p>\n", renderInline(strings.Join(paragraph, "\n"), resolve))
+		}
+	}
+}
+
+// renderList renders the list starting at the given line, returning the index
+// of the line after it. Lines indented past the bullet belong to the item, and
+// are rendered as blocks of their own, which makes for nested lists.
+func renderList(sb *strings.Builder, lines []string, i int, ordered bool, resolve linkResolver) int {
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	indent := len(lines[i]) - len(strings.TrimLeft(lines[i], " \t"))
+	fmt.Fprintf(sb, "<%s>\n", tag)
+	for i < len(lines) {
+		line := lines[i]
+		depth := len(line) - len(strings.TrimLeft(line, " \t"))
+		text, itemOrdered, ok := listItemText(strings.TrimSpace(line))
+		if !ok || depth != indent || itemOrdered != ordered {
+			break
+		}
+		i++
+
+		nested := []string{}
+		for ; i < len(lines); i++ {
+			next := lines[i]
+			t := strings.TrimSpace(next)
+			d := len(next) - len(strings.TrimLeft(next, " \t"))
+			if t == "" {
+				// NOTE: a blank line only continues the item if indented
+				// content follows
+				if i+1 < len(lines) && len(lines[i+1])-len(strings.TrimLeft(lines[i+1], " \t")) > indent && strings.TrimSpace(lines[i+1]) != "" {
+					nested = append(nested, "")
+					continue
+				}
+				break
+			}
+			if d <= indent {
+				break
+			}
+			nested = append(nested, next[min(d, indent+2):])
+		}
+
+		sb.WriteString("<li>")
+		sb.WriteString(renderInline(text, resolve))
+		if len(nested) > 0 {
+			sb.WriteString("\n")
+			renderBlocks(sb, nested, resolve)
+		}
+		sb.WriteString("</li>\n")
+
+		if i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			// NOTE: a blank line between items doesn't end the list
+			if i+1 < len(lines) {
+				if _, o, ok := listItemText(strings.TrimSpace(lines[i+1])); ok && o == ordered {
+					i++
+				}
+			}
+		}
+	}
+	fmt.Fprintf(sb, "</%s>\n", tag)
+	return i
+}
+
+// renderInline renders the inline markup of a line of text. Code spans are cut
+// out first, so that nothing inside them is formatted.
+func renderInline(text string, resolve linkResolver) string {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(text, '`')
+		if start == -1 {
+			break
+		}
+		end := strings.IndexByte(text[start+1:], '`')
+		if end == -1 {
+			break
+		}
+		sb.WriteString(renderSpan(text[:start], resolve))
+		sb.WriteString("<code>" + html.EscapeString(text[start+1:start+1+end]) + "</code>")
+		text = text[start+end+2:]
+	}
+	sb.WriteString(renderSpan(text, resolve))
+	return strings.ReplaceAll(sb.String(), "\n", " ")
+}
+
+// renderSpan renders text without code spans. Links are swapped for
+// placeholders while the emphasis is rendered, so that nothing in their
+// targets, or in the markup made for them, is taken for emphasis.
+func renderSpan(text string, resolve linkResolver) string {
+	links := []string{}
+	placeholder := func(link string) string {
+		links = append(links, link)
+		return fmt.Sprintf("\x00%d\x00", len(links)-1)
+	}
+
+	s := html.EscapeString(strings.ReplaceAll(text, "\x00", ""))
+	s = linkRegex.ReplaceAllStringFunc(s, func(m string) string {
+		target := linkRegex.FindStringSubmatch(m)[1]
+		if href, ok := resolve(target); ok {
+			return placeholder(fmt.Sprintf(`<a class="zettel" href="%s">%s</a>`, html.EscapeString(href), m))
+		}
+		return placeholder(`<span class="broken">` + m + "</span>")
+	})
+	s = mdLinkRegex.ReplaceAllStringFunc(s, func(m string) string {
+		sub := mdLinkRegex.FindStringSubmatch(m)
+		if !safeHref(html.UnescapeString(sub[2])) {
+			return m
+		}
+		return placeholder(fmt.Sprintf(`<a href="%s">%s</a>`, sub[2], renderEmphasis(sub[1])))
+	})
+	s = renderEmphasis(s)
+	return placeholderRegex.ReplaceAllStringFunc(s, func(m string) string {
+		i, _ := strconv.Atoi(strings.Trim(m, "\x00"))
+		return links[i]
+	})
+}
+
+// safeHref tells whether a link target may be used in a page: relative URLs,
+// and those with a scheme known to do nothing but navigate.
+func safeHref(href string) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return false
+	}
+	return slices.Contains([]string{"", "http", "https", "mailto"}, u.Scheme)
+}
+
+func renderEmphasis(s string) string {
+	s = strongRegex.ReplaceAllString(s, "<strong>$1$2</strong>")
+	return emRegex.ReplaceAllString(s, "<em>$1$2</em>")
+}
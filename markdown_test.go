@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testResolver links every target starting with "tmp.", and nothing else.
+func testResolver(target string) (string, bool) {
+	if !strings.HasPrefix(target, "tmp.") {
+		return "", false
+	}
+	return "/z/" + target + "?q=_a_b_", true
+}
+
+func TestRenderInline(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain <text> & more", "plain &lt;text&gt; &amp; more"},
+		{"**strong** and *em* and _em_", "<strong>strong</strong> and <em>em</em> and <em>em</em>"},
+		{"snake_case_name", "snake_case_name"},
+		{"`*code* [[tmp.1]]` *em*", "<code>*code* [[tmp.1]]</code> <em>em</em>"},
+		{"see [[tmp.1]]", `see <a class="zettel" href="/z/tmp.1?q=_a_b_">[[tmp.1]]</a>`},
+		{"*see [[tmp.1]]*", `<em>see <a class="zettel" href="/z/tmp.1?q=_a_b_">[[tmp.1]]</a></em>`},
+		{"[[_draft_]]", `<span class="broken">[[_draft_]]</span>`},
+		{"[a *b*](http://x.org/*y*/__z__)", `<a href="http://x.org/*y*/__z__">a <em>b</em></a>`},
+		{"[x](http://a.org/_p_) and _q_", `<a href="http://a.org/_p_">x</a> and <em>q</em>`},
+		{"[x](javascript:alert(1))", "[x](javascript:alert(1))"},
+		{"[x](JavaScript:alert(1))", "[x](JavaScript:alert(1))"},
+		{"[x](data:text/html,hi)", "[x](data:text/html,hi)"},
+		{"[x](vbscript:msgbox)", "[x](vbscript:msgbox)"},
+		{"[x](java%0ascript:alert)", "[x](java%0ascript:alert)"},
+		{"[x](file:///etc/passwd)", "[x](file:///etc/passwd)"},
+		{"[x](https://x.org/a?b=1&c=2)", `<a href="https://x.org/a?b=1&amp;c=2">x</a>`},
+		{"[x](HTTP://x.org)", `<a href="HTTP://x.org">x</a>`},
+		{"[x](mailto:a@x.org)", `<a href="mailto:a@x.org">x</a>`},
+		{"[x](../tmp.1.html#top)", `<a href="../tmp.1.html#top">x</a>`},
+		{"[x](//x.org/p)", `<a href="//x.org/p">x</a>`},
+		{"a\x000\x00b", "a0b"},
+	}
+	for _, tt := range tests {
+		if got := renderInline(tt.in, testResolver); got != tt.want {
+			t.Errorf("renderInline(%q) =\n%s\nwant\n%s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	body := strings.Join([]string{
+		"# Title *here*",
+		"",
+		"A paragraph",
+		"over two lines.",
+		"",
+		"- one",
+		"- two",
+		"  - nested",
+		"",
+		"1. first",
+		"",
+		"> quoted [[tmp.2]]",
+		"",
+		"```go",
+		"if a < b && *c* {}",
+		"```",
+		"",
+		"---",
+	}, "\n")
+	want := strings.Join([]string{
+		"<h1>Title <em>here</em></h1>",
+		"<p>A paragraph over two lines.</p>",
+		"<ul>",
+		"<li>one</li>",
+		"<li>two",
+		"<ul>",
+		"<li>nested</li>",
+		"</ul>",
+		"</li>",
+		"</ul>",
+		"<ol>",
+		"<li>first</li>",
+		"</ol>",
+		"<blockquote>",
+		`<p>quoted <a class="zettel" href="/z/tmp.2?q=_a_b_">[[tmp.2]]</a></p>`,
+		"</blockquote>",
+		`<pre><code class="language-go">if a &lt; b &amp;&amp; *c* {}</code></pre>`,
+		"<hr>",
+		"",
+	}, "\n")
+	if got := renderMarkdown(body, testResolver); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// what links to pruned zettels are replaced with when tombstoning them
+const tombstoneFormat = "[pruned %s]"
+
+// pruneTargets returns the IDs of all zettels removed by pruning the given
+// zettel or branch, and a function telling whether a link target is removed.
+// A branch left without members is removed as well, as links to it have
+// nothing to resolve to.
+func pruneTargets(cs *changeSet, root ZettelID) ([]string, func(string) bool, error) {
+	under := func(target string) bool {
+		id, err := ParseZettelID(target)
+		if err != nil {
+			return false
+		}
+		return id.String() == root.String() || root.IsAncestorOf(id)
+	}
+
+	allIds, err := cs.ids()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed retrieving all ids: %w", err)
+	}
+	removed := []string{}
+	remaining := map[string]int{} // NOTE: the members left, by sequence key
+	for _, id := range allIds {
+		zid, err := ParseZettelID(id)
+		if err != nil {
+			continue
+		}
+		if under(id) {
+			removed = append(removed, id)
+			remaining[zid.SequenceKey()] += 0
+		} else {
+			remaining[zid.SequenceKey()]++
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil, fmt.Errorf("no zettels found at %q", root)
+	}
+
+	emptied := map[string]bool{}
+	for key, n := range remaining {
+		if id, err := ParseZettelID(key); n == 0 && err == nil && id.IsBranch() {
+			emptied[key] = true
+		}
+	}
+	affected := func(target string) bool {
+		return emptied[target] || under(target)
+	}
+	return removed, affected, nil
+}
+
+// findBrokenLinks returns every link to an affected target from a zettel that
+// is not itself removed.
+func findBrokenLinks(cs *changeSet, removed []string, affected func(string) bool) ([]linkRef, error) {
+	ix, err := getIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving index: %w", err)
+	}
+	isRemoved := map[string]bool{}
+	for _, id := range removed {
+		isRemoved[id] = true
+	}
+	sources := map[string]bool{}
+	for target, linking := range ix.Backlinks {
+		if !affected(target) {
+			continue
+		}
+		for _, s := range linking {
+			if !isRemoved[s] {
+				sources[s] = true
+			}
+		}
+	}
+	sorted := []string{}
+	for s := range sources {
+		sorted = append(sorted, s)
+	}
+	sort.Strings(sorted)
+	return findLinkRefs(sorted, cs.read, affected)
+}
+
+// planPrune plans removing the given zettels, and rewriting the zettels with
+// broken links using the replace function, unless it is nil.
+func planPrune(cs *changeSet, removed []string, broken []linkRef, replace func(content string) string) error {
+	for _, id := range removed {
+		err := cs.remove(id + ".md")
+		if err != nil {
+			return err
+		}
+	}
+	if replace == nil {
+		return nil
+	}
+	for _, b := range broken {
+		content, exists, err := cs.read(b.Source + ".md")
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		err = cs.write(b.Source+".md", replace(content))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stripLinks removes links to affected targets. Lines holding nothing but the
+// link are removed, while links in running text are replaced by the plain ID.
+func stripLinks(content string, affected func(string) bool) string {
+	content, _ = removeLinkLines(content, affected)
+	content, _ = replaceLinks(content, affected, func(target string) string { return target })
+	return content
+}
+
+// tombstoneLinks replaces links to affected targets with a marker, so that it
+// is still visible that something was linked there.
+func tombstoneLinks(content string, affected func(string) bool) string {
+	content, _ = replaceLinks(content, affected, func(target string) string {
+		return fmt.Sprintf(tombstoneFormat, target)
+	})
+	return content
+}
+
+// replaceLinks replaces every link to an affected target, markup included,
+// with the given replacement text.
+func replaceLinks(content string, affected func(string) bool, replacement func(string) string) (string, int) {
+	n := 0
+	ret := linkRegex.ReplaceAllStringFunc(content, func(link string) string {
+		target := link[2 : len(link)-2]
+		if !affected(target) {
+			return link
+		}
+		n++
+		return replacement(target)
+	})
+	return ret, n
+}
+
+var PruneCommand = cmdtree.Cmd{
+	CommandName: "prune",
+	Exec: func(args []string) error {
+		dryRun := popFlag(&args, "--dry-run", "-n")
+		strip := popFlag(&args, "--strip")
+		tombstone := popFlag(&args, "--tombstone")
+		force := popFlag(&args, "--force", "-f")
+		if len(args) != 1 {
+			return fmt.Errorf("usage: zet2 prune [--dry-run] [--strip|--tombstone|--force] <id>")
+		}
+		if strip && tombstone {
+			return fmt.Errorf("--strip and --tombstone cannot be combined")
+		}
+		root, err := ParseZettelID(idFromArg(args[0]))
+		if err != nil {
+			return fmt.Errorf("invalid id: %w", err)
+		}
+
+		cs := newChangeSet("prune", "")
+		removed, affected, err := pruneTargets(cs, root)
+		if err != nil {
+			return err
+		}
+		broken, err := findBrokenLinks(cs, removed, affected)
+		if err != nil {
+			return err
+		}
+
+		for _, b := range broken {
+			fmt.Fprintf(os.Stderr, "%s.md:%d: %s\n", b.Source, b.Line, b.Text)
+		}
+		var replace func(string) string
+		action := "left dangling"
+		switch {
+		case strip:
+			replace = func(content string) string { return stripLinks(content, affected) }
+			action = "stripped"
+		case tombstone:
+			replace = func(content string) string { return tombstoneLinks(content, affected) }
+			action = "tombstoned"
+		case len(broken) > 0 && !force:
+			return fmt.Errorf("pruning %s would break the %d links above, give --strip, --tombstone or --force", root, len(broken))
+		}
+
+		err = planPrune(cs, removed, broken, replace)
+		if err != nil {
+			return fmt.Errorf("failed to plan prune: %w", err)
+		}
+		cs.op.Description = fmt.Sprintf("prune %s, %d zettels removed, %d links %s", root, len(removed), len(broken), action)
+		if dryRun {
+			return previewOperation(cs)
+		}
+		err = cs.commit()
+		if err != nil {
+			return fmt.Errorf("failed to prune: %w", err)
+		}
+		for _, id := range removed {
+			fmt.Printf("Removed %q\n", id)
+		}
+		fmt.Println(cs.op.Description)
+		return nil
+	},
+}
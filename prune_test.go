@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPruneEmptiedBranch(t *testing.T) {
+	tests := []struct {
+		root    string
+		removed []string
+		broken  []string // the sources of the broken links
+	}{
+		// NOTE: the only member of tmp.4c, so the branch link goes too
+		{"tmp.4c1", []string{"tmp.4c1"}, []string{"tmp.4"}},
+		// NOTE: tmp.4d keeps a member
+		{"tmp.4d1", []string{"tmp.4d1"}, []string{}},
+		{"tmp.4c", []string{"tmp.4c1"}, []string{"tmp.4"}},
+		{"tmp.4", []string{"tmp.4", "tmp.4c1", "tmp.4d1", "tmp.4d2"}, []string{"tmp.1"}},
+	}
+	for _, tt := range tests {
+		useTestKasten(t, map[string]string{
+			"tmp.1":   "---\nzettel: tmp.1\n---\n\n[[tmp.4]]\n",
+			"tmp.4":   "---\nzettel: tmp.4\n---\n\n[[tmp.4c]]\n[[tmp.4d]]\n",
+			"tmp.4c1": "---\nzettel: tmp.4c1\n---\n\nfirst\n",
+			"tmp.4d1": "---\nzettel: tmp.4d1\n---\n\nfirst\n",
+			"tmp.4d2": "---\nzettel: tmp.4d2\n---\n\nsecond\n",
+		})
+		cs := newChangeSet("prune", "")
+		removed, affected, err := pruneTargets(cs, mustParse(t, tt.root))
+		if err != nil {
+			t.Fatalf("prune %s: %s", tt.root, err)
+		}
+		slices.Sort(removed)
+		if !slices.Equal(removed, tt.removed) {
+			t.Errorf("prune %s removes %q, want %q", tt.root, removed, tt.removed)
+		}
+		broken, err := findBrokenLinks(cs, removed, affected)
+		if err != nil {
+			t.Fatalf("prune %s: %s", tt.root, err)
+		}
+		sources := []string{}
+		for _, b := range broken {
+			sources = append(sources, b.Source)
+		}
+		if !slices.Equal(sources, tt.broken) {
+			t.Errorf("prune %s breaks links in %q, want %q", tt.root, sources, tt.broken)
+		}
+	}
+
+	useTestKasten(t, map[string]string{
+		"tmp.4":   "---\nzettel: tmp.4\n---\n\nsee [[tmp.4c]]\n",
+		"tmp.4c1": "---\nzettel: tmp.4c1\n---\n\nfirst\n",
+	})
+	_, affected, err := pruneTargets(newChangeSet("prune", ""), mustParse(t, "tmp.4c1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tombstoneLinks("see [[tmp.4c]]\n", affected); got != "see [pruned tmp.4c]\n" {
+		t.Errorf("tombstoned %q", got)
+	}
+}
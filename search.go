@@ -0,0 +1,528 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/morngrar/zet2/cmdtree"
+	"golang.org/x/term"
+)
+
+// Full text search is backed by an inverted index, mapping every term to the
+// zettels containing it and the positions it occurs at, which is what makes
+// phrase queries possible. It is stored next to the main index and kept up to
+// date the same way, re-indexing only the zettels that have changed. Results
+// are ranked with BM25.
+
+const searchFileName = "search.json"
+
+// bump this whenever the tokenization or the stored data changes
+const searchVersion = 1
+
+// BM25 parameters, the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type searchDoc struct {
+	ModTime int64    `json:"mtime"`
+	Size    int64    `json:"size"`
+	Length  int      `json:"length"` // number of tokens
+	Terms   []string `json:"terms"`  // distinct terms, to remove postings
+}
+
+type searchIndex struct {
+	Version  int                         `json:"version"`
+	Docs     map[string]*searchDoc       `json:"docs"`
+	Postings map[string]map[string][]int `json:"postings"` // term -> id -> positions
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		Version:  searchVersion,
+		Docs:     map[string]*searchDoc{},
+		Postings: map[string]map[string][]int{},
+	}
+}
+
+// getSearchIndex loads the search index and brings it up to date.
+func getSearchIndex() (*searchIndex, error) {
+	ix, err := getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	si := newSearchIndex()
+	buf, err := os.ReadFile(path.Join(indexDir(), searchFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read search index: %w", err)
+	}
+	if err == nil {
+		loaded := &searchIndex{}
+		err = json.Unmarshal(buf, loaded)
+		// NOTE: like the main index, a bad search index is just rebuilt
+		if err == nil && loaded.Version == searchVersion && loaded.Docs != nil && loaded.Postings != nil {
+			si = loaded
+		}
+	}
+
+	err = si.refresh(ix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to update search index: %w", err)
+	}
+	return si, nil
+}
+
+func (si *searchIndex) refresh(ix *zetIndex) error {
+	dirty := false
+	for id, e := range ix.Entries {
+		d, ok := si.Docs[id]
+		if ok && d.ModTime == e.ModTime && d.Size == e.Size {
+			continue
+		}
+		content, exists, err := readZettelFile(id + ".md")
+		if err != nil {
+			return err
+		}
+		si.removeDoc(id)
+		if exists {
+			si.addDoc(id, content, e)
+		}
+		dirty = true
+	}
+	for id := range si.Docs {
+		if _, ok := ix.Entries[id]; !ok {
+			si.removeDoc(id)
+			dirty = true
+		}
+	}
+	if !dirty {
+		return nil
+	}
+	return si.save()
+}
+
+func (si *searchIndex) addDoc(id, content string, e *indexEntry) {
+	_, body := splitFrontmatter(content)
+	tokens := tokenize(body)
+	terms := []string{}
+	for pos, t := range tokens {
+		postings, ok := si.Postings[t]
+		if !ok {
+			postings = map[string][]int{}
+			si.Postings[t] = postings
+		}
+		if _, seen := postings[id]; !seen {
+			terms = append(terms, t)
+		}
+		postings[id] = append(postings[id], pos)
+	}
+	sort.Strings(terms)
+	si.Docs[id] = &searchDoc{ModTime: e.ModTime, Size: e.Size, Length: len(tokens), Terms: terms}
+}
+
+func (si *searchIndex) removeDoc(id string) {
+	d, ok := si.Docs[id]
+	if !ok {
+		return
+	}
+	for _, t := range d.Terms {
+		delete(si.Postings[t], id)
+		if len(si.Postings[t]) == 0 {
+			delete(si.Postings, t)
+		}
+	}
+	delete(si.Docs, id)
+}
+
+func (si *searchIndex) save() error {
+	err := ensureIndexDir()
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(si)
+	if err != nil {
+		return fmt.Errorf("unable to serialize search index: %w", err)
+	}
+	return writeFileAtomic(path.Join(indexDir(), searchFileName), buf)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenize splits text into lower case words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isWordRune(r) })
+}
+
+type searchQuery struct {
+	terms    []string
+	phrases  [][]string
+	prefixes []string
+	tags     []string
+}
+
+// parseQuery parses a query of words, "quoted phrases", and the filters
+// prefix:<id prefix> and tag:<tag>. Words that tokenize into more than one term,
+// like 'zettel-kasten', are taken as phrases.
+func parseQuery(s string) (searchQuery, error) {
+	var q searchQuery
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		var word string
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end == -1 {
+				return q, fmt.Errorf("unterminated phrase in query")
+			}
+			word, s = s[1:end+1], s[end+2:]
+			if tokens := tokenize(word); len(tokens) > 0 {
+				q.phrases = append(q.phrases, tokens)
+			}
+			continue
+		}
+
+		end := strings.IndexAny(s, " \t")
+		if end == -1 {
+			end = len(s)
+		}
+		word, s = s[:end], s[end:]
+		if v, ok := strings.CutPrefix(word, "prefix:"); ok && v != "" {
+			q.prefixes = append(q.prefixes, v)
+			continue
+		}
+		if v, ok := strings.CutPrefix(word, "tag:"); ok && v != "" {
+			q.tags = append(q.tags, normalizeTag(v))
+			continue
+		}
+		tokens := tokenize(word)
+		switch {
+		case len(tokens) == 1:
+			q.terms = append(q.terms, tokens[0])
+		case len(tokens) > 1:
+			q.phrases = append(q.phrases, tokens)
+		}
+	}
+	if len(q.terms) == 0 && len(q.phrases) == 0 && len(q.prefixes) == 0 && len(q.tags) == 0 {
+		return q, fmt.Errorf("empty query")
+	}
+	return q, nil
+}
+
+// queryFromArgs joins the arguments of the search command into a query. An
+// argument with whitespace in it was quoted in the shell, and is taken as a
+// phrase, unless it holds quotes of its own.
+func queryFromArgs(args []string) string {
+	words := []string{}
+	for _, a := range args {
+		if strings.ContainsAny(a, " \t") && !strings.Contains(a, `"`) {
+			a = `"` + a + `"`
+		}
+		words = append(words, a)
+	}
+	return strings.Join(words, " ")
+}
+
+// words returns all the words searched for, for highlighting.
+func (q searchQuery) words() map[string]bool {
+	ret := map[string]bool{}
+	for _, t := range q.terms {
+		ret[t] = true
+	}
+	for _, p := range q.phrases {
+		for _, t := range p {
+			ret[t] = true
+		}
+	}
+	return ret
+}
+
+type searchResult struct {
+	id    string
+	score float64
+}
+
+// bm25 scores a term occurring tf times in a document of the given length, when
+// df of the n documents contain it.
+func (si *searchIndex) bm25(tf, df, length int, avgLength float64) float64 {
+	n := float64(len(si.Docs))
+	idf := math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+	norm := float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*(1-bm25B+bm25B*float64(length)/avgLength))
+	return idf * norm
+}
+
+// phraseCounts returns the number of times the phrase occurs in each document
+// containing it.
+func (si *searchIndex) phraseCounts(phrase []string) map[string]int {
+	counts := map[string]int{}
+	for id, starts := range si.Postings[phrase[0]] {
+		n := 0
+		for _, p := range starts {
+			matched := true
+			for i, t := range phrase[1:] {
+				if !containsInt(si.Postings[t][id], p+i+1) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				n++
+			}
+		}
+		if n > 0 {
+			counts[id] = n
+		}
+	}
+	return counts
+}
+
+func containsInt(sorted []int, x int) bool {
+	i := sort.SearchInts(sorted, x)
+	return i < len(sorted) && sorted[i] == x
+}
+
+// search returns the documents matching the query, best match first. Any of the
+// terms may match, but all phrases and filters must.
+func (si *searchIndex) search(q searchQuery, ix *zetIndex) []searchResult {
+	total := 0
+	for _, d := range si.Docs {
+		total += d.Length
+	}
+	avgLength := max(1, float64(total)/float64(max(1, len(si.Docs))))
+
+	scores := map[string]float64{}
+	required := []map[string]int{}
+	for _, p := range q.phrases {
+		counts := si.phraseCounts(p)
+		required = append(required, counts)
+		for id, n := range counts {
+			scores[id] += si.bm25(n, len(counts), si.Docs[id].Length, avgLength)
+		}
+	}
+	for _, t := range q.terms {
+		postings := si.Postings[t]
+		for id, positions := range postings {
+			scores[id] += si.bm25(len(positions), len(postings), si.Docs[id].Length, avgLength)
+		}
+	}
+	if len(q.terms) == 0 && len(q.phrases) == 0 {
+		for id := range si.Docs {
+			scores[id] = 0 // NOTE: only filters given
+		}
+	}
+
+	results := []searchResult{}
+	for id, score := range scores {
+		matches := true
+		for _, r := range required {
+			if r[id] == 0 {
+				matches = false
+			}
+		}
+		if !matches || !matchesPrefixes(id, q.prefixes) || !matchesTags(ix.Entries[id], q.tags) {
+			continue
+		}
+		results = append(results, searchResult{id, score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return compareIdStrings(results[i].id, results[j].id) < 0
+	})
+	return results
+}
+
+// compareIdStrings compares IDs in folgezettel order, falling back to lexical
+// order for invalid ones.
+func compareIdStrings(a, b string) int {
+	x, errA := ParseZettelID(a)
+	y, errB := ParseZettelID(b)
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+	return x.Compare(y)
+}
+
+// matchesPrefixes tells whether the ID is under any of the given prefixes, as
+// by ZettelID.HasPrefix. An empty prefix matches anything.
+func matchesPrefixes(id string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	zid, err := ParseZettelID(id)
+	for _, p := range prefixes {
+		if p == "" || (err == nil && zid.HasPrefix(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesTags(e *indexEntry, tags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	if e == nil {
+		return false
+	}
+	for _, t := range tags {
+		if !slices.Contains(e.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// snippet returns the line of the body best matching the query, cut to the
+// given width around the first match, with matches highlighted if asked to.
+func snippet(content string, words map[string]bool, width int, highlight bool) string {
+	_, body := splitFrontmatter(content)
+	best, bestCount := "", 0
+	for line := range strings.SplitSeq(body, "\n") {
+		count := 0
+		for _, t := range tokenize(line) {
+			if words[t] {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = strings.TrimSpace(line), count
+		}
+	}
+	if best == "" {
+		return firstLine(content)
+	}
+
+	spans := wordSpans(best)
+	first := -1
+	for _, s := range spans {
+		if words[strings.ToLower(best[s[0]:s[1]])] {
+			first = s[0]
+			break
+		}
+	}
+
+	// NOTE: cut to the width, in runes, keeping the first match in view
+	runes := []rune(best)
+	start := 0
+	if first != -1 {
+		start = max(0, utf8.RuneCountInString(best[:first])-width/4)
+	}
+	end := min(len(runes), start+width)
+	start = max(0, min(start, end-width))
+	cut := string(runes[start:end])
+	if start > 0 {
+		cut = "..." + cut
+	}
+	if end < len(runes) {
+		cut += "..."
+	}
+	if !highlight {
+		return cut
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, s := range wordSpans(cut) {
+		if !words[strings.ToLower(cut[s[0]:s[1]])] {
+			continue
+		}
+		sb.WriteString(cut[last:s[0]])
+		sb.WriteString(ansiMatch + cut[s[0]:s[1]] + ansiReset)
+		last = s[1]
+	}
+	sb.WriteString(cut[last:])
+	return sb.String()
+}
+
+// wordSpans returns the byte offsets of the start and end of every word.
+func wordSpans(s string) [][2]int {
+	ret := [][2]int{}
+	start := -1
+	for i, r := range s {
+		if isWordRune(r) {
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+		if start != -1 {
+			ret = append(ret, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		ret = append(ret, [2]int{start, len(s)})
+	}
+	return ret
+}
+
+var SearchCommand = cmdtree.Cmd{
+	CommandName: "search",
+	Exec: func(args []string) error {
+		idsOnly := popFlag(&args, "--ids-only", "-l")
+		limitArg, hasLimit, err := popFlagValue(&args, "--limit")
+		if err != nil {
+			return err
+		}
+		limit := 20
+		if hasLimit {
+			limit, err = strconv.Atoi(limitArg)
+			if err != nil || limit < 1 {
+				return fmt.Errorf("invalid limit %q", limitArg)
+			}
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("usage: zet2 search [--limit n] [--ids-only] <terms, \"phrases\", prefix:p, tag:t>")
+		}
+		q, err := parseQuery(queryFromArgs(args))
+		if err != nil {
+			return err
+		}
+
+		si, err := getSearchIndex()
+		if err != nil {
+			return err
+		}
+		ix, err := getIndex()
+		if err != nil {
+			return err
+		}
+		results := si.search(q, ix)
+		if len(results) > limit {
+			results = results[:limit]
+		}
+
+		width, tty := 80, false
+		if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			tty = true
+			if w > 0 {
+				width = w
+			}
+		}
+		words := q.words()
+		for _, r := range results {
+			if idsOnly {
+				fmt.Println(r.id)
+				continue
+			}
+			content, _, err := readZettelFile(r.id + ".md")
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s  %.2f\n    %s\n", r.id, r.score, snippet(content, words, max(20, width-8), tty))
+		}
+		return nil
+	},
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"os"
+	"path"
+	"slices"
+	"testing"
+)
+
+// useTestKasten points the zettel dir at a fresh directory holding the given
+// zettels, by ID.
+func useTestKasten(t *testing.T, zettels map[string]string) {
+	t.Helper()
+	oldDir, oldIndex := zetDir, currentIndex
+	t.Cleanup(func() {
+		zetDir, currentIndex = oldDir, oldIndex
+	})
+	zetDir, currentIndex = t.TempDir(), nil
+	for id, content := range zettels {
+		writeTestZettel(t, id, content)
+	}
+}
+
+func writeTestZettel(t *testing.T, id, content string) {
+	t.Helper()
+	err := os.WriteFile(path.Join(zetDir, id+".md"), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// memorySearchIndex indexes the given zettels without touching the disk.
+func memorySearchIndex(zettels map[string]string) (*searchIndex, *zetIndex) {
+	si, ix := newSearchIndex(), newIndex("")
+	for id, content := range zettels {
+		e := &indexEntry{ID: id, Size: int64(len(content)), Tags: parseTags(content)}
+		ix.Entries[id] = e
+		si.addDoc(id, content, e)
+	}
+	return si, ix
+}
+
+func resultIds(results []searchResult) []string {
+	ids := []string{}
+	for _, r := range results {
+		ids = append(ids, r.id)
+	}
+	return ids
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("The Zettel-kasten, (c) 2024: æøå_x!")
+	want := []string{"the", "zettel", "kasten", "c", "2024", "æøå", "x"}
+	if !slices.Equal(got, want) {
+		t.Errorf("tokenize = %q, want %q", got, want)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	q, err := parseQuery(`Foo "bar  Baz" zettel-kasten prefix:j1 tag:#Idea`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(q.terms, []string{"foo"}) {
+		t.Errorf("terms = %q", q.terms)
+	}
+	if len(q.phrases) != 2 || !slices.Equal(q.phrases[0], []string{"bar", "baz"}) || !slices.Equal(q.phrases[1], []string{"zettel", "kasten"}) {
+		t.Errorf("phrases = %q", q.phrases)
+	}
+	if !slices.Equal(q.prefixes, []string{"j1"}) || !slices.Equal(q.tags, []string{"idea"}) {
+		t.Errorf("filters = %q %q", q.prefixes, q.tags)
+	}
+
+	for _, bad := range []string{"", "   ", `"unterminated`, `"" ...`} {
+		if q, err := parseQuery(bad); err == nil {
+			t.Errorf("parseQuery(%q) = %+v, want error", bad, q)
+		}
+	}
+}
+
+func TestQueryFromArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"hello", "world"}, "hello world"},
+		{[]string{"hello world"}, `"hello world"`},
+		{[]string{"hello\tworld", "prefix:j1"}, "\"hello\tworld\" prefix:j1"},
+		{[]string{`"hello world" there`}, `"hello world" there`},
+		{[]string{`"hello`, `world"`}, `"hello world"`},
+	}
+	for _, tt := range tests {
+		if got := queryFromArgs(tt.args); got != tt.want {
+			t.Errorf("queryFromArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	si, ix := memorySearchIndex(map[string]string{
+		"tmp.1": "hello world\n",
+		"tmp.2": "hello there world\n",
+	})
+	q, err := parseQuery(queryFromArgs([]string{"hello world"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultIds(si.search(q, ix)); !slices.Equal(got, []string{"tmp.1"}) {
+		t.Errorf("search for the argument \"hello world\" = %q, want [tmp.1]", got)
+	}
+}
+
+var searchCorpus = map[string]string{
+	"tmp.1":  "---\nzettel: tmp.1\ntags: [idea]\n---\n\napple apple apple banana\n",
+	"tmp.2":  "---\nzettel: tmp.2\n---\n\napple banana cherry and some more words to make it longer\n",
+	"tmp.3":  "---\nzettel: tmp.3\n---\n\ncherry banana, said the zettel\n",
+	"j1.1":   "---\nzettel: j1.1\ntags: [idea]\n---\n\nbanana cherry\n",
+	"j10.1":  "---\nzettel: j10.1\n---\n\nbanana cherry pie\n",
+	"tmp.4":  "---\nzettel: tmp.4\ntitle: apple\n---\n\nno fruit in the body\n",
+	"tmp.4a": "not a zettel, but indexed all the same\n",
+}
+
+func TestSearchRanking(t *testing.T) {
+	si, ix := memorySearchIndex(searchCorpus)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// NOTE: more occurrences in a shorter zettel rank higher, and the
+		// frontmatter isn't searched
+		{"apple", []string{"tmp.1", "tmp.2"}},
+		// NOTE: the rare term outweighs the common one
+		{"apple banana", []string{"tmp.1", "tmp.2", "j1.1", "j10.1", "tmp.3"}},
+		{"pie", []string{"j10.1"}},
+		{"missing", []string{}},
+		{`"banana cherry"`, []string{"j1.1", "j10.1", "tmp.2"}},
+		{`"cherry banana"`, []string{"tmp.3"}},
+		{`"banana cherry" pie`, []string{"j10.1", "j1.1", "tmp.2"}},
+		{"banana tag:idea", []string{"j1.1", "tmp.1"}},
+		{"tag:idea", []string{"j1.1", "tmp.1"}},
+		{"tag:idea tag:other", []string{}},
+		{"cherry prefix:tmp", []string{"tmp.3", "tmp.2"}},
+		{"cherry prefix:j1", []string{"j1.1"}},
+		{"cherry prefix:j", []string{}},
+	}
+	for _, tt := range tests {
+		q, err := parseQuery(tt.query)
+		if err != nil {
+			t.Fatalf("parseQuery(%q): %s", tt.query, err)
+		}
+		got := resultIds(si.search(q, ix))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("search %q = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchScoresDescend(t *testing.T) {
+	si, ix := memorySearchIndex(searchCorpus)
+	q, _ := parseQuery("apple banana cherry")
+	results := si.search(q, ix)
+	for i := 1; i < len(results); i++ {
+		if results[i].score > results[i-1].score {
+			t.Errorf("results out of order: %+v", results)
+		}
+	}
+	if len(results) == 0 || results[0].score <= 0 {
+		t.Errorf("expected positive scores, got %+v", results)
+	}
+}
+
+func TestSearchIndexRefresh(t *testing.T) {
+	useTestKasten(t, map[string]string{
+		"tmp.1": "---\nzettel: tmp.1\n---\n\napple\n",
+		"tmp.2": "---\nzettel: tmp.2\n---\n\nbanana\n",
+	})
+	search := func(query string) []string {
+		t.Helper()
+		si, err := getSearchIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ix, err := getIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := parseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resultIds(si.search(q, ix))
+	}
+
+	if got := search("apple"); !slices.Equal(got, []string{"tmp.1"}) {
+		t.Fatalf("search apple = %q", got)
+	}
+	if _, err := os.Stat(path.Join(indexDir(), searchFileName)); err != nil {
+		t.Fatalf("search index wasn't saved: %s", err)
+	}
+
+	// NOTE: the stored index is stale after these, and must be brought up to
+	// date from the files
+	writeTestZettel(t, "tmp.2", "---\nzettel: tmp.2\n---\n\napple pie\n")
+	writeTestZettel(t, "tmp.3", "---\nzettel: tmp.3\n---\n\nbanana split\n")
+	err := os.Remove(path.Join(zetDir, "tmp.1.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentIndex = nil
+
+	if got := search("apple"); !slices.Equal(got, []string{"tmp.2"}) {
+		t.Errorf("search apple after changes = %q, want [tmp.2]", got)
+	}
+	if got := search("banana"); !slices.Equal(got, []string{"tmp.3"}) {
+		t.Errorf("search banana after changes = %q, want [tmp.3]", got)
+	}
+
+	// NOTE: a corrupt index is rebuilt rather than failing
+	err = os.WriteFile(path.Join(indexDir(), searchFileName), []byte("{not json"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := search(`"apple pie"`); !slices.Equal(got, []string{"tmp.2"}) {
+		t.Errorf("search after corrupting the index = %q, want [tmp.2]", got)
+	}
+}
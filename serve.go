@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// zet2 serve exposes the kasten over HTTP, as a JSON API under /api/ and as a
+// read-only web viewer everywhere else. Zettels are resolved the same way as
+// by the resolve command, so branches and prefixes lead to their first member.
+//
+//	GET  /api/zettels?prefix=p&tag=t      list zettels, like zet2 list --json
+//	POST /api/zettels                     create one, from {"prefix", "title"}
+//	GET  /api/zettels/{id}                a zettel, with its content
+//	GET  /api/zettels/{id}/backlinks      the lines linking to it
+//	GET  /api/resolve/{id}                resolve a zettel, branch or prefix
+//	GET  /api/resolve/{id}/next           the next zettel in the sequence
+//	GET  /api/resolve/{id}/previous       the previous zettel
+//	GET  /api/search?q=query&limit=n      ranked search, like zet2 search
+
+const defaultServeAddr = "localhost:8080"
+
+type apiZettel struct {
+	listEntry
+	Frontmatter map[string]string `json:"frontmatter"`
+	Links       []string          `json:"links"`
+	Content     string            `json:"content"`
+}
+
+type apiResolved struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+}
+
+type apiBacklink struct {
+	Source string `json:"source"`
+	Line   int    `json:"line"`
+	Text   string `json:"text"`
+}
+
+type apiSearchResult struct {
+	ID      string  `json:"id"`
+	Title   string  `json:"title"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+// zetServer serializes all requests, as the index and the zettel dir are not
+// safe for concurrent use.
+type zetServer struct {
+	mu sync.Mutex
+
+	// the host names requests may be made to, which keeps other sites from
+	// reaching the server by pointing their own names at it
+	hosts []string
+}
+
+func newZetServer(addr string) *zetServer {
+	s := &zetServer{hosts: []string{"localhost", "127.0.0.1", "::1"}}
+	host, _, err := net.SplitHostPort(addr)
+	if err == nil && host != "" && !slices.Contains(s.hosts, strings.ToLower(host)) {
+		s.hosts = append(s.hosts, strings.ToLower(host))
+	}
+	return s
+}
+
+func (s *zetServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/zettels", s.apiList)
+	mux.HandleFunc("POST /api/zettels", s.apiCreate)
+	mux.HandleFunc("GET /api/zettels/{id}", s.apiGet)
+	mux.HandleFunc("GET /api/zettels/{id}/backlinks", s.apiBacklinks)
+	mux.HandleFunc("GET /api/resolve/{id}", s.apiResolve)
+	mux.HandleFunc("GET /api/resolve/{id}/next", s.apiResolve)
+	mux.HandleFunc("GET /api/resolve/{id}/previous", s.apiResolve)
+	mux.HandleFunc("GET /api/search", s.apiSearch)
+	mux.HandleFunc("GET /{$}", s.viewIndex)
+	mux.HandleFunc("GET /prefix/{prefix}", s.viewPrefix)
+	mux.HandleFunc("GET /z/{id}", s.viewZettel)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host // NOTE: no port
+		}
+		if !slices.Contains(s.hosts, strings.ToLower(host)) {
+			http.Error(w, fmt.Sprintf("unexpected host %q", r.Host), http.StatusForbidden)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// safeIdArg tells whether an ID from a request is safe to resolve to a file,
+// staying within the zettel dir.
+func safeIdArg(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/\\") && !strings.HasPrefix(id, ".")
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(buf, '\n'))
+}
+
+func writeJsonError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *zetServer) apiList(w http.ResponseWriter, r *http.Request) {
+	entries, err := listEntries(r.URL.Query().Get("prefix"))
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	tags := []string{}
+	for _, t := range r.URL.Query()["tag"] {
+		tags = append(tags, normalizeTag(t))
+	}
+	entries = slices.DeleteFunc(entries, func(e listEntry) bool {
+		return !matchesTags(&indexEntry{Tags: e.Tags}, tags)
+	})
+	writeJson(w, http.StatusOK, entries)
+}
+
+func (s *zetServer) apiCreate(w http.ResponseWriter, r *http.Request) {
+	// NOTE: requiring JSON keeps web pages from creating zettels with plain
+	// form posts
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeJsonError(w, http.StatusUnsupportedMediaType, fmt.Errorf("expected application/json"))
+		return
+	}
+	var req struct {
+		Prefix string `json:"prefix"`
+		Title  string `json:"title"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.Prefix == "" {
+		writeJsonError(w, http.StatusBadRequest, fmt.Errorf("no prefix given"))
+		return
+	}
+	id, filePath, _, err := createZettel(req.Prefix, req.Title)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	commitCreated(id)
+	writeJson(w, http.StatusCreated, apiResolved{ID: id, Path: filePath})
+}
+
+func (s *zetServer) apiGet(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ix, err := getIndex()
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	e, ok := ix.Entries[id]
+	if !ok {
+		writeJsonError(w, http.StatusNotFound, fmt.Errorf("zettel %q does not exist", id))
+		return
+	}
+	content, _, err := readZettelFile(id + ".md")
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, http.StatusOK, apiZettel{
+		listEntry: listEntry{
+			ID:    id,
+			Title: e.Title,
+			Date:  e.Frontmatter["date"],
+			Tags:  e.Tags,
+			Path:  zettelPath(id),
+		},
+		Frontmatter: e.Frontmatter,
+		Links:       e.Links,
+		Content:     content,
+	})
+}
+
+func (s *zetServer) apiBacklinks(w http.ResponseWriter, r *http.Request) {
+	id, err := ParseZettelID(r.PathValue("id"))
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, fmt.Errorf("invalid zettel id: %w", err))
+		return
+	}
+	refs, err := backlinks(id)
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ret := []apiBacklink{}
+	for _, ref := range refs {
+		ret = append(ret, apiBacklink{Source: ref.Source, Line: ref.Line, Text: ref.Text})
+	}
+	writeJson(w, http.StatusOK, ret)
+}
+
+// apiResolve serves the resolve endpoints, as the resolve command does.
+func (s *zetServer) apiResolve(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !safeIdArg(id) {
+		writeJsonError(w, http.StatusBadRequest, fmt.Errorf("invalid zettel id %q", id))
+		return
+	}
+	var resolved, filePath string
+	var err error
+	switch r.Pattern {
+	case "GET /api/resolve/{id}/next", "GET /api/resolve/{id}/previous":
+		if _, err := parseIdArg(id); err != nil {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("invalid zettel id: %w", err))
+			return
+		}
+		if strings.HasSuffix(r.Pattern, "/next") {
+			resolved, filePath, err = determineNextZet(id)
+		} else {
+			resolved, filePath, err = determinePrevZet(id)
+		}
+	default:
+		resolved, filePath, err = resolveZettel(id)
+	}
+	if errors.Is(err, errNotFound) {
+		writeJsonError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, http.StatusOK, apiResolved{ID: resolved, Path: filePath})
+}
+
+func (s *zetServer) apiSearch(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := 20
+	if arg := r.URL.Query().Get("limit"); arg != "" {
+		limit, err = strconv.Atoi(arg)
+		if err != nil || limit < 1 {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", arg))
+			return
+		}
+	}
+
+	si, err := getSearchIndex()
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ix, err := getIndex()
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	results := si.search(q, ix)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	words := q.words()
+	ret := []apiSearchResult{}
+	for _, res := range results {
+		content, _, err := readZettelFile(res.id + ".md")
+		if err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		ret = append(ret, apiSearchResult{
+			ID:      res.id,
+			Title:   ix.Entries[res.id].Title,
+			Score:   res.score,
+			Snippet: snippet(content, words, 80, false),
+		})
+	}
+	writeJson(w, http.StatusOK, ret)
+}
+
+func writePage(w http.ResponseWriter, page htmlPage) {
+	var buf bytes.Buffer
+	err := htmlPageTemplate.Execute(&buf, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func viewerHref(id string) string {
+	return "/z/" + url.PathEscape(id)
+}
+
+func (s *zetServer) viewIndex(w http.ResponseWriter, r *http.Request) {
+	ids, err := getAllIds()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var list bytes.Buffer
+	list.WriteString("<ul>\n")
+	for _, root := range buildTree(ids) {
+		fmt.Fprintf(&list, `<li><a href="/prefix/%s">%s</a></li>`+"\n",
+			template.HTMLEscapeString(url.PathEscape(root.Prefix)), template.HTMLEscapeString(root.Prefix))
+	}
+	list.WriteString("</ul>\n")
+	writePage(w, htmlPage{Title: "Kasten", Body: template.HTML(list.String())})
+}
+
+func (s *zetServer) viewPrefix(w http.ResponseWriter, r *http.Request) {
+	ix, err := getIndex()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	prefix := r.PathValue("prefix")
+	var root *treeNode
+	for _, n := range buildTree(ix.ids()) {
+		if n.Prefix == prefix {
+			root = n
+		}
+	}
+	if root == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writePage(w, htmlPage{
+		Title: prefix,
+		Nav:   []htmlLink{{Label: "all prefixes", Href: "/"}},
+		Body:  renderTreeHtml(root.Children, ix, viewerHref),
+	})
+}
+
+func (s *zetServer) viewZettel(w http.ResponseWriter, r *http.Request) {
+	if !safeIdArg(r.PathValue("id")) {
+		http.NotFound(w, r)
+		return
+	}
+	id, _, err := resolveZettel(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if id != r.PathValue("id") {
+		http.Redirect(w, r, viewerHref(id), http.StatusFound)
+		return
+	}
+	zid, err := ParseZettelID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ix, err := getIndex()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	content, _, err := readZettelFile(id + ".md")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e := ix.Entries[id]
+	_, body := splitFrontmatter(content)
+
+	page := htmlPage{
+		Title: id,
+		Meta:  e.Frontmatter["date"],
+		Body: template.HTML(renderMarkdown(body, func(target string) (string, bool) {
+			if !safeIdArg(target) {
+				return "", false
+			}
+			resolved, _, err := resolveZettel(target)
+			return viewerHref(resolved), err == nil
+		})),
+	}
+	if e.Title != "" {
+		page.Title = e.Title
+	}
+	for _, t := range e.Tags {
+		page.Meta += " #" + t
+	}
+
+	page.Nav = append(page.Nav, htmlLink{Label: zid.Prefix, Href: "/prefix/" + url.PathEscape(zid.Prefix)})
+	if parent, err := zid.Parent(); err == nil {
+		if _, ok := ix.Entries[parent.String()]; ok {
+			page.Nav = append(page.Nav, htmlLink{Label: "parent " + parent.String(), Href: viewerHref(parent.String())})
+		}
+	}
+	if prev, _, err := determinePrevZet(id); err == nil {
+		page.Nav = append(page.Nav, htmlLink{Label: "previous " + prev, Href: viewerHref(prev)})
+	}
+	if next, _, err := determineNextZet(id); err == nil {
+		page.Nav = append(page.Nav, htmlLink{Label: "next " + next, Href: viewerHref(next)})
+	}
+
+	for _, source := range ix.backlinksOf(zid) {
+		page.Backlinks = append(page.Backlinks, htmlBacklink{ID: source, Title: ix.Entries[source].Title, Href: viewerHref(source)})
+	}
+	writePage(w, page)
+}
+
+var ServeCommand = cmdtree.Cmd{
+	CommandName: "serve",
+	Exec: func(args []string) error {
+		addr, hasAddr, err := popFlagValue(&args, "--addr")
+		if err != nil {
+			return err
+		}
+		if len(args) > 0 {
+			return fmt.Errorf("usage: zet2 serve [--addr host:port]")
+		}
+		if !hasAddr {
+			addr = defaultServeAddr
+		}
+
+		s := newZetServer(addr)
+		server := &http.Server{
+			Addr:              addr,
+			Handler:           s.handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		fmt.Printf("Serving the kasten at http://%s/\n", addr)
+		return server.ListenAndServe()
+	},
+}
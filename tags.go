@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+)
+
+// Tags are given in the frontmatter, either as a flow list, 'tags: [a, b]', or
+// as a block list of '- a' lines below 'tags:', as well as inline in the body,
+// as '#tag'. Tags are case insensitive, and kept in lower case.
+
+var inlineTagRegex = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_\-/]*\p{L}[\p{L}\p{N}_\-/]*)`)
+
+// validTagRegex matches the tags that can be written inline, which all tags
+// are held to
+var validTagRegex = regexp.MustCompile(`^[\p{L}\p{N}_\-/]*\p{L}[\p{L}\p{N}_\-/]*$`)
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// parseTags returns the tags of a zettel, from the frontmatter and the body,
+// without duplicates and in the order found.
+func parseTags(content string) []string {
+	front, body := splitFrontmatter(content)
+	tags := frontmatterTags(front)
+
+	inCode := false
+	for line := range strings.SplitSeq(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		for _, m := range inlineTagRegex.FindAllStringSubmatch(line, -1) {
+			tags = append(tags, normalizeTag(m[1]))
+		}
+	}
+
+	ret := []string{}
+	for _, t := range tags {
+		if t != "" && !slices.Contains(ret, t) {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// frontmatterTags returns the tags listed in the 'tags' field of the preamble.
+func frontmatterTags(front string) []string {
+	tags := []string{}
+	for _, item := range frontmatterTagItems(front) {
+		if t := normalizeTag(item); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// frontmatterTagItems returns the items of the 'tags' field of the preamble as
+// they are written, but unquoted. A scalar is taken as a comma separated list.
+func frontmatterTagItems(front string) []string {
+	f := readFrontmatter(front).get("tags")
+	if f == nil {
+		return nil
+	}
+	if items, ok := f.list(); ok {
+		return items
+	}
+	return parseTagList(f.scalar())
+}
+
+// parseTagList parses a comma separated list of tags.
+func parseTagList(value string) []string {
+	ret := []string{}
+	for t := range strings.SplitSeq(value, ",") {
+		t = strings.Trim(strings.TrimSpace(t), `"'`)
+		if t != "" {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// setFrontmatterTags replaces the tags in the preamble of a zettel with the
+// given ones, leaving every other field untouched. The field is removed if
+// there are no tags.
+func setFrontmatterTags(id, content string, tags []string) string {
+	return editFrontmatter(id, content, func(fm *frontmatter) {
+		if len(tags) == 0 {
+			fm.unset("tags")
+			return
+		}
+		fm.setList("tags", tags)
+	})
+}
+
+// planTagChange adds adding and removing tags of a zettel to the change set.
+func planTagChange(cs *changeSet, id string, add, remove []string) error {
+	content, exists, err := cs.read(id + ".md")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("zettel %q does not exist", id)
+	}
+	front, body := splitFrontmatter(content)
+
+	// NOTE: the items are kept as written, and compared the way they are
+	// parsed, so that the untouched ones are written back as they were
+	items := frontmatterTagItems(front)
+	tagged := func(t string) bool {
+		return slices.ContainsFunc(items, func(item string) bool { return normalizeTag(item) == t })
+	}
+	for _, t := range add {
+		if !tagged(t) {
+			items = append(items, t)
+		}
+	}
+	for _, t := range remove {
+		if !tagged(t) {
+			if slices.Contains(parseTags(body), t) {
+				return fmt.Errorf("%q is tagged %q inline, which must be removed by editing the zettel", id, t)
+			}
+			return fmt.Errorf("%q is not tagged %q", id, t)
+		}
+		items = slices.DeleteFunc(items, func(item string) bool { return normalizeTag(item) == t })
+	}
+	return cs.write(id+".md", setFrontmatterTags(id, content, items))
+}
+
+// tagArgs parses the arguments of the tag subcommands. Only new tags are held
+// to be valid, so that any tag found in a preamble can be removed.
+func tagArgs(args []string, validate bool) (string, []string, error) {
+	if len(args) < 2 {
+		return "", nil, fmt.Errorf("expected a zettel and at least one tag")
+	}
+	id := idFromArg(args[0])
+	tags := []string{}
+	for _, a := range args[1:] {
+		t := normalizeTag(a)
+		if t == "" || validate && !validTagRegex.MatchString(t) {
+			return "", nil, fmt.Errorf("invalid tag %q, tags are made of letters, digits, '_', '-' and '/'", a)
+		}
+		tags = append(tags, t)
+	}
+	return id, tags, nil
+}
+
+var TagCommand = cmdtree.Cmd{
+	CommandName: "tag",
+	SubCommands: []*cmdtree.Cmd{
+		{
+			CommandName: "add",
+			Exec: func(args []string) error {
+				id, tags, err := tagArgs(args, true)
+				if err != nil {
+					return fmt.Errorf("usage: zet2 tag add <id> <tag>...: %w", err)
+				}
+				cs := newChangeSet("tag", fmt.Sprintf("tag %s with %s", id, strings.Join(tags, ", ")))
+				err = planTagChange(cs, id, tags, nil)
+				if err != nil {
+					return err
+				}
+				return cs.commit()
+			},
+		},
+		{
+			CommandName: "remove",
+			Exec: func(args []string) error {
+				id, tags, err := tagArgs(args, false)
+				if err != nil {
+					return fmt.Errorf("usage: zet2 tag remove <id> <tag>...: %w", err)
+				}
+				cs := newChangeSet("tag", fmt.Sprintf("untag %s from %s", id, strings.Join(tags, ", ")))
+				err = planTagChange(cs, id, nil, tags)
+				if err != nil {
+					return err
+				}
+				return cs.commit()
+			},
+		},
+	},
+	Exec: func(args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("usage: zet2 tag add|remove <id> <tag>...")
+		}
+		if len(args) > 1 {
+			return fmt.Errorf("unknown tag subcommand %q", args[0])
+		}
+		ix, err := getIndex()
+		if err != nil {
+			return err
+		}
+		e, ok := ix.Entries[idFromArg(args[0])]
+		if !ok {
+			return fmt.Errorf("zettel %q does not exist", args[0])
+		}
+		for _, t := range e.Tags {
+			fmt.Println(t)
+		}
+		return nil
+	},
+}
+
+var TagsCommand = cmdtree.Cmd{
+	CommandName: "tags",
+	Exec: func(args []string) error {
+		ix, err := getIndex()
+		if err != nil {
+			return err
+		}
+		counts := map[string]int{}
+		for _, e := range ix.Entries {
+			for _, t := range e.Tags {
+				counts[t]++
+			}
+		}
+		tags := []string{}
+		for t := range counts {
+			tags = append(tags, t)
+		}
+		sort.Slice(tags, func(i, j int) bool {
+			if counts[tags[i]] != counts[tags[j]] {
+				return counts[tags[i]] > counts[tags[j]]
+			}
+			return tags[i] < tags[j]
+		})
+		for _, t := range tags {
+			fmt.Printf("%5d %s\n", counts[t], t)
+		}
+		return nil
+	},
+}
+
+var TaggedCommand = cmdtree.Cmd{
+	CommandName: "tagged",
+	Exec: func(args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("usage: zet2 tagged <tag>...")
+		}
+		ix, err := getIndex()
+		if err != nil {
+			return err
+		}
+		wanted := []string{}
+		for _, a := range args {
+			wanted = append(wanted, normalizeTag(a))
+		}
+		ids := []string{}
+		for id, e := range ix.Entries {
+			if matchesTags(e, wanted) {
+				ids = append(ids, id)
+			}
+		}
+		slices.SortFunc(ids, compareIdStrings)
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// New zettels are made from templates, looked up in the template dir (by
+// default templates/ next to the config file) in the following order:
+//
+//   - command/<command>.md, for zettels made by a given command, like branch
+//   - prefix/<prefix>.md, for zettels with a given prefix
+//   - default.md
+//
+// falling back to the built in template. The variables {{id}}, {{date}},
+// {{title}}, {{prefix}}, {{parent}} and {{parent_title}} are substituted as
+// is in the body, and quoted as YAML needs in the preamble, while {{cursor}}
+// marks where the editor puts the cursor.
+
+const cursorMarker = "{{cursor}}"
+
+const builtinTemplate = "---\nzettel: {{id}}\ndate: {{date}}\n---\n\n" + cursorMarker + "\n\n"
+
+func templateDir() (string, error) {
+	dir := currentConfig.value("template_dir")
+	if dir == "" {
+		return path.Join(path.Dir(currentConfig.path), "templates"), nil
+	}
+	return expandHome(dir)
+}
+
+// findTemplate returns the template for a zettel made by the given command.
+func findTemplate(command string, id ZettelID) (string, error) {
+	dir, err := templateDir()
+	if err != nil {
+		return "", err
+	}
+	candidates := []string{
+		path.Join(dir, "command", command+".md"),
+		path.Join(dir, "prefix", id.Prefix+".md"),
+		path.Join(dir, "default.md"),
+	}
+	for _, c := range candidates {
+		buf, err := os.ReadFile(c)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("unable to read template %q: %w", c, err)
+		}
+		return string(buf), nil
+	}
+	return builtinTemplate, nil
+}
+
+// newZettelContent returns the initial content of a new zettel made by the
+// given command, with the given title unless it's empty, and the line to put
+// the cursor on. The parent is read with the given function, so that it can
+// be seen as planned in a change set.
+func newZettelContent(command, zettelId, title string, read func(name string) (string, bool, error)) (string, int, error) {
+	id, err := ParseZettelID(zettelId)
+	if err != nil {
+		return "", 0, err
+	}
+	tmpl, err := findTemplate(command, id)
+	if err != nil {
+		return "", 0, err
+	}
+
+	parent, parentTitle := "", ""
+	if p, err := id.Parent(); err == nil {
+		parent = p.String()
+		content, exists, err := read(parent + ".md")
+		if err != nil {
+			return "", 0, err
+		}
+		if exists {
+			parentTitle = zettelTitle(content)
+		}
+	}
+
+	vars := []string{
+		"{{id}}", zettelId,
+		"{{date}}", timestamp(),
+		"{{title}}", title,
+		"{{prefix}}", id.Prefix,
+		"{{parent}}", parent,
+		"{{parent_title}}", parentTitle,
+	}
+	bodyVars, frontVars := []string{cursorMarker, ""}, []string{cursorMarker, ""}
+	for i := 0; i < len(vars); i += 2 {
+		bodyVars = append(bodyVars, vars[i], vars[i+1])
+		frontVars = append(frontVars, vars[i], formatYamlScalar(vars[i+1], false))
+	}
+	bodyReplacer, frontReplacer := strings.NewReplacer(bodyVars...), strings.NewReplacer(frontVars...)
+	front, _ := splitFrontmatter(tmpl)
+	expand := func(from, to int) string {
+		split := min(max(len(front), from), to)
+		return frontReplacer.Replace(tmpl[from:split]) + bodyReplacer.Replace(tmpl[split:to])
+	}
+
+	// NOTE: without a marker, the cursor goes to the end
+	start, end := len(tmpl), len(tmpl)
+	if i := strings.Index(tmpl, cursorMarker); i != -1 {
+		start, end = i, i+len(cursorMarker)
+	}
+	content := expand(0, start)
+	cursor := strings.Count(content, "\n") + 1
+	content += expand(end, len(tmpl))
+
+	if title != "" && !strings.Contains(tmpl, "{{title}}") {
+		front, _ := splitFrontmatter(content)
+		content = editFrontmatter(zettelId, content, func(fm *frontmatter) {
+			fm.setScalar("title", title)
+		})
+		newFront, _ := splitFrontmatter(content)
+		if cursor > strings.Count(front, "\n") {
+			cursor += strings.Count(newFront, "\n") - strings.Count(front, "\n")
+		}
+	}
+	return content, cursor, nil
+}
+
+// bodyLine returns the line where the body of a zettel starts, past the
+// preamble and the blank line following it.
+func bodyLine(content string) int {
+	front, body := splitFrontmatter(content)
+	line := strings.Count(front, "\n") + 1
+	if front != "" && strings.HasPrefix(body, "\n") {
+		line++
+	}
+	return line
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestNewZettelContentQuotesPreamble(t *testing.T) {
+	useTestKasten(t, map[string]string{
+		"tmp.4": "---\nzettel: tmp.4\ntitle: \"#parent: one\"\n---\n\nparent\n",
+	})
+	oldConfig := currentConfig
+	t.Cleanup(func() {
+		currentConfig = oldConfig
+	})
+	dir := t.TempDir()
+	currentConfig = &configFile{path: path.Join(dir, "config")}
+	currentConfig.set("template_dir", dir)
+	tmpl := "---\nzettel: {{id}}\ntitle: {{title}}\nparent: {{parent_title}}\n---\n\n# {{title}}\n\n{{cursor}}from {{parent_title}}\n"
+	err := os.WriteFile(path.Join(dir, "default.md"), []byte(tmpl), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, cursor, err := newZettelContent("branch", "tmp.4a1", "a: b", readZettelFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "---\nzettel: tmp.4a1\ntitle: \"a: b\"\nparent: \"#parent: one\"\n---\n\n# a: b\n\nfrom #parent: one\n"
+	if content != want {
+		t.Errorf("got\n%s\nwant\n%s", content, want)
+	}
+	if cursor != 9 {
+		t.Errorf("cursor on line %d, want 9", cursor)
+	}
+	front, _ := splitFrontmatter(content)
+	if got := readFrontmatter(front).get("title").scalar(); got != "a: b" {
+		t.Errorf("title read back as %q", got)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/morngrar/zet2/cmdtree"
+	"golang.org/x/term"
+)
+
+// treeNode is a node in the folgezettel tree of the kasten. The roots are the
+// prefixes, their children are the top level zettels, and the children of a
+// zettel are the members of its branches, in folgezettel order. E.g:
+//
+//	tmp
+//	|-- tmp.4
+//	|   |-- tmp.4a1
+//	|   |-- tmp.4a2
+//	|   `-- tmp.4b1
+//	`-- tmp.5
+type treeNode struct {
+	ID       ZettelID
+	Prefix   string // the label of nodes that aren't zettels, like prefixes
+	Children []*treeNode
+}
+
+func (n *treeNode) isPrefix() bool {
+	return n.Prefix != ""
+}
+
+func (n *treeNode) name() string {
+	if n.isPrefix() {
+		return n.Prefix
+	}
+	return n.ID.String()
+}
+
+// buildTree builds the folgezettel tree of the given IDs. A zettel whose parent
+// doesn't exist is attached to its closest existing ancestor, or to its prefix
+// if there is none, so that nothing goes missing from the tree.
+func buildTree(ids []string) []*treeNode {
+	parsed := []ZettelID{}
+	for _, s := range ids {
+		id, err := ParseZettelID(s)
+		if err != nil || id.IsBranch() {
+			continue
+		}
+		parsed = append(parsed, id)
+	}
+	// NOTE: folgezettel order puts every zettel after its ancestors
+	slices.SortFunc(parsed, ZettelID.Compare)
+
+	roots := []*treeNode{}
+	prefixes := map[string]*treeNode{}
+	nodes := map[string]*treeNode{}
+	for _, id := range parsed {
+		node := &treeNode{ID: id}
+		nodes[id.String()] = node
+
+		parent := closestTreeAncestor(id, nodes)
+		if parent == nil {
+			parent = prefixes[id.Prefix]
+			if parent == nil {
+				parent = &treeNode{Prefix: id.Prefix}
+				prefixes[id.Prefix] = parent
+				roots = append(roots, parent)
+			}
+		}
+		parent.Children = append(parent.Children, node)
+	}
+	return roots
+}
+
+func closestTreeAncestor(id ZettelID, nodes map[string]*treeNode) *treeNode {
+	for {
+		parent, err := id.Parent()
+		if err != nil {
+			return nil
+		}
+		if n, ok := nodes[parent.String()]; ok {
+			return n
+		}
+		id = parent
+	}
+}
+
+// findTreeNode returns the node with the given name in the tree, or nil.
+func findTreeNode(roots []*treeNode, name string) *treeNode {
+	for _, n := range roots {
+		if n.name() == name {
+			return n
+		}
+		if found := findTreeNode(n.Children, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// treeRow is a node of the tree as a line of output, where lead is the ASCII
+// art connecting it to its parent.
+type treeRow struct {
+	node  *treeNode
+	depth int
+	lead  string
+}
+
+// flattenTree lists the nodes of the tree depth first, down to the given depth
+// below the roots, where a negative depth means no limit.
+func flattenTree(roots []*treeNode, maxDepth int) []treeRow {
+	rows := []treeRow{}
+	var walk func(n *treeNode, depth int, lead, indent string)
+	walk = func(n *treeNode, depth int, lead, indent string) {
+		rows = append(rows, treeRow{node: n, depth: depth, lead: lead})
+		if maxDepth >= 0 && depth >= maxDepth {
+			return
+		}
+		for i, c := range n.Children {
+			if i == len(n.Children)-1 {
+				walk(c, depth+1, indent+"`-- ", indent+"    ")
+			} else {
+				walk(c, depth+1, indent+"|-- ", indent+"|   ")
+			}
+		}
+	}
+	for _, r := range roots {
+		walk(r, 0, "", "")
+	}
+	return rows
+}
+
+// subtree returns the roots to show for the given zettel, branch or prefix.
+func subtree(roots []*treeNode, name string) ([]*treeNode, error) {
+	if n := findTreeNode(roots, name); n != nil {
+		return []*treeNode{n}, nil
+	}
+
+	// NOTE: a branch isn't a node of its own, so gather its members
+	id, err := ParseZettelID(name)
+	if err != nil || !id.IsBranch() {
+		return nil, fmt.Errorf("no zettel, branch or prefix %q", name)
+	}
+	parent, _ := id.Parent()
+	branch := &treeNode{Prefix: name}
+	if p := findTreeNode(roots, parent.String()); p != nil {
+		for _, c := range p.Children {
+			if b, err := c.ID.Branch(); err == nil && b.String() == name {
+				branch.Children = append(branch.Children, c)
+			}
+		}
+	}
+	if len(branch.Children) == 0 {
+		return nil, fmt.Errorf("no zettels in branch %q", name)
+	}
+	return []*treeNode{branch}, nil
+}
+
+// firstLine returns the first non-empty line of the body of a zettel.
+func firstLine(content string) string {
+	_, body := splitFrontmatter(content)
+	for line := range strings.SplitSeq(body, "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			return trimmed
+		}
+	}
+	return ""
+}
+
+var TreeCommand = cmdtree.Cmd{
+	CommandName: "tree",
+	Exec: func(args []string) error {
+		preview := popFlag(&args, "--preview", "-p")
+		title := popFlag(&args, "--title", "-t")
+		depthArg, hasDepth, err := popFlagValue(&args, "--depth")
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 {
+			return fmt.Errorf("usage: zet2 tree [--depth n] [--preview|--title] [id|branch|prefix]")
+		}
+		depth := -1
+		if hasDepth {
+			depth, err = strconv.Atoi(depthArg)
+			if err != nil || depth < 0 {
+				return fmt.Errorf("invalid depth %q", depthArg)
+			}
+		}
+
+		ids, err := getAllIds()
+		if err != nil {
+			return fmt.Errorf("unable to list zettels: %w", err)
+		}
+		roots := buildTree(ids)
+		if len(args) == 1 {
+			roots, err = subtree(roots, idFromArg(args[0]))
+			if err != nil {
+				return err
+			}
+		}
+
+		width := 0
+		if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			width = w
+		}
+		for _, r := range flattenTree(roots, depth) {
+			line := r.lead + r.node.name()
+			if (preview || title) && !r.node.isPrefix() {
+				content, _, err := readZettelFile(r.node.name() + ".md")
+				if err != nil {
+					return err
+				}
+				text := firstLine(content)
+				if title {
+					text = zettelTitle(content)
+				}
+				if text != "" {
+					line += "  " + text
+				}
+			}
+			if width > 0 {
+				line = truncateRunes(line, width)
+			}
+			fmt.Println(line)
+		}
+		return nil
+	},
+}
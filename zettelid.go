@@ -0,0 +1,358 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ZettelID is a parsed zettel ID. An ID consists of a prefix, optionally
+// separated by a dot from the sequence number that follows it, and then
+// alternating alphabetic (branch) and numeric (sequence) segments.
+//
+// E.g: j1.1.2b1a3 -> prefix "j1.1", dotted, segments "2", "b", "1", "a", "3"
+//
+// An ID ending in a numeric segment refers to a zettel, while one ending in an
+// alphabetic segment refers to a branch, e.g. tmp.4a is the branch containing
+// tmp.4a1, tmp.4a2 and so on.
+type ZettelID struct {
+	Prefix   string
+	Dotted   bool
+	Segments []string
+}
+
+// ParseZettelID parses the given string as a zettel ID, returning an error if
+// it is malformed.
+func ParseZettelID(s string) (ZettelID, error) {
+	var id ZettelID
+	if s == "" {
+		return id, fmt.Errorf("empty zettel ID")
+	}
+
+	// NOTE: the prefix may itself contain dots and digits (e.g. 'j1.1'), so the
+	// structured part of the ID starts at the first digit after the last dot
+	lastDot := strings.LastIndex(s, ".")
+	start := strings.IndexFunc(s[lastDot+1:], isDigit)
+	if start == -1 {
+		return id, fmt.Errorf("zettel ID %q has no sequence number", s)
+	}
+	start += lastDot + 1
+
+	if lastDot != -1 && start == lastDot+1 {
+		id.Prefix = s[:lastDot]
+		id.Dotted = true
+	} else {
+		id.Prefix = s[:start]
+	}
+	if id.Prefix == "" {
+		return id, fmt.Errorf("zettel ID %q has no prefix", s)
+	}
+	if strings.ContainsAny(id.Prefix, "/\\[] \t\n") {
+		return id, fmt.Errorf("zettel ID %q has invalid characters in its prefix", s)
+	}
+
+	rest := s[start:]
+	for rest != "" {
+		numeric := isDigit(rune(rest[0]))
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return isDigit(r) != numeric
+		})
+		if end == -1 {
+			end = len(rest)
+		}
+		seg := rest[:end]
+		if !numeric && strings.IndexFunc(seg, func(r rune) bool { return r < 'a' || r > 'z' }) != -1 {
+			return id, fmt.Errorf("zettel ID %q has invalid branch segment %q", s, seg)
+		}
+		id.Segments = append(id.Segments, seg)
+		rest = rest[end:]
+	}
+	return id, nil
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isNumericSegment(seg string) bool {
+	return seg != "" && isDigit(rune(seg[0]))
+}
+
+func (id ZettelID) String() string {
+	sep := ""
+	if id.Dotted {
+		sep = "."
+	}
+	return id.Prefix + sep + strings.Join(id.Segments, "")
+}
+
+// IsBranch reports whether the ID refers to a branch rather than a zettel.
+func (id ZettelID) IsBranch() bool {
+	return !isNumericSegment(id.Leaf())
+}
+
+// IsTopLevel reports whether the ID is part of the top level sequence of its
+// prefix, i.e. not in any branch.
+func (id ZettelID) IsTopLevel() bool {
+	return len(id.Segments) == 1
+}
+
+// Leaf returns the last segment of the ID.
+func (id ZettelID) Leaf() string {
+	return id.Segments[len(id.Segments)-1]
+}
+
+// Seq returns the sequence number of a zettel ID.
+func (id ZettelID) Seq() (int, error) {
+	if id.IsBranch() {
+		return 0, fmt.Errorf("%q is a branch, not a zettel", id)
+	}
+	return strconv.Atoi(id.Leaf())
+}
+
+// SequenceKey returns the identifier of the sequence a zettel is part of. For
+// zettels in a branch, this is the branch ID, and for top level zettels it is
+// the prefix. E.g: tmp.4a2 -> tmp.4a, tmp.4 -> tmp
+func (id ZettelID) SequenceKey() string {
+	if id.IsTopLevel() {
+		return id.Prefix
+	}
+	return id.withSegments(id.Segments[:len(id.Segments)-1]).String()
+}
+
+// Parent returns the zettel that the ID branches off from. E.g: tmp.4a1 ->
+// tmp.4, tmp.4a -> tmp.4. Top level zettels have no parent.
+func (id ZettelID) Parent() (ZettelID, error) {
+	if id.IsBranch() {
+		return id.withSegments(id.Segments[:len(id.Segments)-1]), nil
+	}
+	if id.IsTopLevel() {
+		return ZettelID{}, fmt.Errorf("top level zettel %q has no parent", id)
+	}
+	return id.withSegments(id.Segments[:len(id.Segments)-2]), nil
+}
+
+// Branch returns the branch that a zettel is a member of. E.g: tmp.4a1 ->
+// tmp.4a. Branch IDs return themselves, and top level zettels, being in no
+// branch, return an error.
+func (id ZettelID) Branch() (ZettelID, error) {
+	if id.IsBranch() {
+		return id, nil
+	}
+	if id.IsTopLevel() {
+		return ZettelID{}, fmt.Errorf("top level zettel %q is not in a branch", id)
+	}
+	return id.withSegments(id.Segments[:len(id.Segments)-1]), nil
+}
+
+// Next returns the following ID in the same sequence. For a zettel this is the
+// next sequence number, e.g. tmp.4a1 -> tmp.4a2, and for a branch it is the
+// next sibling branch, e.g. tmp.4a -> tmp.4b.
+func (id ZettelID) Next() (ZettelID, error) {
+	leaf := id.Leaf()
+	var next string
+	if isNumericSegment(leaf) {
+		n, err := strconv.Atoi(leaf)
+		if err != nil {
+			return ZettelID{}, fmt.Errorf("invalid sequence number in %q: %w", id, err)
+		}
+		next = strconv.Itoa(n + 1)
+	} else {
+		var err error
+		next, err = incrementAlpha(leaf)
+		if err != nil {
+			return ZettelID{}, fmt.Errorf("unable to increment %q: %w", id, err)
+		}
+	}
+	return id.withLeaf(next), nil
+}
+
+// Prev returns the preceding ID in the same sequence, which is the inverse of
+// Next. Returns an error if the ID is the first possible one.
+func (id ZettelID) Prev() (ZettelID, error) {
+	leaf := id.Leaf()
+	var prev string
+	if isNumericSegment(leaf) {
+		n, err := strconv.Atoi(leaf)
+		if err != nil {
+			return ZettelID{}, fmt.Errorf("invalid sequence number in %q: %w", id, err)
+		}
+		if n <= 0 {
+			return ZettelID{}, fmt.Errorf("%q is the first possible zettel in its sequence", id)
+		}
+		prev = strconv.Itoa(n - 1)
+	} else {
+		var err error
+		prev, err = decrementAlpha(leaf)
+		if err != nil {
+			return ZettelID{}, fmt.Errorf("unable to decrement %q: %w", id, err)
+		}
+	}
+	return id.withLeaf(prev), nil
+}
+
+// Child returns the ID with the given segment appended, which must be
+// alphabetic for zettels and numeric for branches. E.g: tmp.4 + c -> tmp.4c,
+// tmp.4c + 1 -> tmp.4c1
+func (id ZettelID) Child(seg string) (ZettelID, error) {
+	if seg == "" {
+		return ZettelID{}, fmt.Errorf("empty segment")
+	}
+	child, err := ParseZettelID(id.String() + seg)
+	if err != nil {
+		return ZettelID{}, err
+	}
+	if len(child.Segments) != len(id.Segments)+1 {
+		return ZettelID{}, fmt.Errorf("segment %q cannot be appended to %q", seg, id)
+	}
+	return child, nil
+}
+
+// Compare orders IDs in folgezettel order, returning -1, 0 or 1. Within the
+// same prefix a zettel comes before its branches, which again come before the
+// next zettel in the sequence, e.g: tmp.4, tmp.4a1, tmp.4a2, tmp.4b1, tmp.5
+func (id ZettelID) Compare(other ZettelID) int {
+	if c := comparePrefixes(id.Prefix, other.Prefix); c != 0 {
+		return c
+	}
+	if id.Dotted != other.Dotted {
+		if id.Dotted {
+			return 1
+		}
+		return -1
+	}
+	for i := range min(len(id.Segments), len(other.Segments)) {
+		if c := compareSegments(id.Segments[i], other.Segments[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(id.Segments), len(other.Segments))
+}
+
+// IsAncestorOf reports whether other is somewhere in the subtree of id, e.g.
+// tmp.4 is an ancestor of tmp.4a1 and tmp.4a1b2, but not of tmp.5 or tmp.45.
+func (id ZettelID) IsAncestorOf(other ZettelID) bool {
+	if id.Prefix != other.Prefix || id.Dotted != other.Dotted {
+		return false
+	}
+	if len(other.Segments) <= len(id.Segments) {
+		return false
+	}
+	for i, seg := range id.Segments {
+		if other.Segments[i] != seg {
+			return false
+		}
+	}
+	return true
+}
+
+// HasPrefix reports whether the ID is under the given prefix, which must match
+// its prefix up to a dot, e.g. j1 covers j1.4 and j1.1.2, but not j10.1, nor
+// j1a1, which has the prefix j.
+func (id ZettelID) HasPrefix(prefix string) bool {
+	return id.Prefix == prefix || strings.HasPrefix(id.Prefix, prefix+".")
+}
+
+func (id ZettelID) withSegments(segments []string) ZettelID {
+	return ZettelID{
+		Prefix:   id.Prefix,
+		Dotted:   id.Dotted,
+		Segments: append([]string{}, segments...),
+	}
+}
+
+func (id ZettelID) withLeaf(leaf string) ZettelID {
+	ret := id.withSegments(id.Segments)
+	ret.Segments[len(ret.Segments)-1] = leaf
+	return ret
+}
+
+// compareSegments compares numeric segments by value and alphabetic segments
+// by length first, so that 'z' comes before 'za'.
+func compareSegments(a, b string) int {
+	an, bn := isNumericSegment(a), isNumericSegment(b)
+	if an && bn {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		if c := cmp.Compare(x, y); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	}
+	if an != bn {
+		if an {
+			return -1
+		}
+		return 1
+	}
+	if c := cmp.Compare(len(a), len(b)); c != 0 {
+		return c
+	}
+	return cmp.Compare(a, b)
+}
+
+// comparePrefixes does a natural comparison of prefixes, so that embedded
+// numbers are ordered by value, e.g. tmp.9 before tmp.10.
+func comparePrefixes(a, b string) int {
+	for a != "" && b != "" {
+		var x, y string
+		x, a = splitRun(a)
+		y, b = splitRun(b)
+		if isNumericSegment(x) && isNumericSegment(y) {
+			xn, _ := strconv.Atoi(x)
+			yn, _ := strconv.Atoi(y)
+			if c := cmp.Compare(xn, yn); c != 0 {
+				return c
+			}
+		}
+		if c := cmp.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// splitRun splits off the leading run of either digits or non-digits.
+func splitRun(s string) (string, string) {
+	numeric := isDigit(rune(s[0]))
+	end := strings.IndexFunc(s, func(r rune) bool { return isDigit(r) != numeric })
+	if end == -1 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// incrementAlpha takes an alphabetic branch segment and returns its
+// zettelkasten increment. E.g: a -> b, z -> za. Returns error on invalid
+// input.
+func incrementAlpha(seg string) (string, error) {
+	if seg == "" {
+		return "", fmt.Errorf("empty branch segment")
+	}
+	last := seg[len(seg)-1]
+	if last < 'a' || last > 'z' {
+		return "", fmt.Errorf("invalid branch segment %q", seg)
+	}
+	if last == 'z' {
+		return seg + "a", nil // z -> za
+	}
+	return seg[:len(seg)-1] + string(last+1), nil
+}
+
+// decrementAlpha is the inverse of incrementAlpha.
+func decrementAlpha(seg string) (string, error) {
+	if seg == "" {
+		return "", fmt.Errorf("empty branch segment")
+	}
+	last := seg[len(seg)-1]
+	switch {
+	case last < 'a' || last > 'z':
+		return "", fmt.Errorf("invalid branch segment %q", seg)
+	case last > 'a':
+		return seg[:len(seg)-1] + string(last-1), nil
+	case len(seg) > 1 && seg[len(seg)-2] == 'z':
+		return seg[:len(seg)-1], nil // za -> z
+	}
+	return "", fmt.Errorf("%q is the first possible branch", seg)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseZettelID(t *testing.T) {
+	tests := []struct {
+		in       string
+		prefix   string
+		dotted   bool
+		segments []string
+	}{
+		{"tmp.1", "tmp", true, []string{"1"}},
+		{"tmp.4a1", "tmp", true, []string{"4", "a", "1"}},
+		{"tmp.4a", "tmp", true, []string{"4", "a"}},
+		{"j1.1.2b1a3", "j1.1", true, []string{"2", "b", "1", "a", "3"}},
+		{"j1", "j", false, []string{"1"}},
+		{"j12za3", "j", false, []string{"12", "za", "3"}},
+		{"a.b.10", "a.b", true, []string{"10"}},
+	}
+	for _, tt := range tests {
+		id, err := ParseZettelID(tt.in)
+		if err != nil {
+			t.Errorf("ParseZettelID(%q): unexpected error: %s", tt.in, err)
+			continue
+		}
+		if id.Prefix != tt.prefix || id.Dotted != tt.dotted || !slices.Equal(id.Segments, tt.segments) {
+			t.Errorf("ParseZettelID(%q) = %q %v %q, want %q %v %q", tt.in, id.Prefix, id.Dotted, id.Segments, tt.prefix, tt.dotted, tt.segments)
+		}
+		if id.String() != tt.in {
+			t.Errorf("ParseZettelID(%q).String() = %q", tt.in, id.String())
+		}
+	}
+}
+
+func TestParseZettelIDInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"tmp",
+		"tmp.",
+		"j1.1.",
+		".1",
+		"1",
+		"tmp.4A1",
+		"tmp.4é1",
+		"a/b.1",
+		"[[tmp.1]]",
+		"tmp 1.1",
+	} {
+		if id, err := ParseZettelID(in); err == nil {
+			t.Errorf("ParseZettelID(%q) = %#v, want error", in, id)
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) ZettelID {
+	t.Helper()
+	id, err := ParseZettelID(s)
+	if err != nil {
+		t.Fatalf("ParseZettelID(%q): %s", s, err)
+	}
+	return id
+}
+
+func TestZettelIDRelations(t *testing.T) {
+	tests := []struct {
+		id          string
+		branch      bool
+		topLevel    bool
+		sequenceKey string
+		parent      string // empty if there is none
+	}{
+		{"tmp.4", false, true, "tmp", ""},
+		{"tmp.4a", true, false, "tmp.4", "tmp.4"},
+		{"tmp.4a1", false, false, "tmp.4a", "tmp.4"},
+		{"tmp.4a1b2", false, false, "tmp.4a1b", "tmp.4a1"},
+		{"j1.1.2b1", false, false, "j1.1.2b", "j1.1.2"},
+		{"j3", false, true, "j", ""},
+	}
+	for _, tt := range tests {
+		id := mustParse(t, tt.id)
+		if id.IsBranch() != tt.branch {
+			t.Errorf("%s.IsBranch() = %v", tt.id, id.IsBranch())
+		}
+		if id.IsTopLevel() != tt.topLevel {
+			t.Errorf("%s.IsTopLevel() = %v", tt.id, id.IsTopLevel())
+		}
+		if got := id.SequenceKey(); got != tt.sequenceKey {
+			t.Errorf("%s.SequenceKey() = %q, want %q", tt.id, got, tt.sequenceKey)
+		}
+		parent, err := id.Parent()
+		switch {
+		case tt.parent == "" && err == nil:
+			t.Errorf("%s.Parent() = %q, want error", tt.id, parent)
+		case tt.parent != "" && err != nil:
+			t.Errorf("%s.Parent(): unexpected error: %s", tt.id, err)
+		case tt.parent != "" && parent.String() != tt.parent:
+			t.Errorf("%s.Parent() = %q, want %q", tt.id, parent, tt.parent)
+		}
+	}
+}
+
+func TestZettelIDNextPrev(t *testing.T) {
+	tests := []struct {
+		id   string
+		next string
+	}{
+		{"tmp.1", "tmp.2"},
+		{"tmp.9", "tmp.10"},
+		{"tmp.4a1", "tmp.4a2"},
+		{"tmp.4a", "tmp.4b"},
+		{"tmp.4y", "tmp.4z"},
+		{"tmp.4z", "tmp.4za"},
+		{"tmp.4za", "tmp.4zb"},
+		{"tmp.4zz", "tmp.4zza"},
+		{"j1.1.2b", "j1.1.2c"},
+	}
+	for _, tt := range tests {
+		next, err := mustParse(t, tt.id).Next()
+		if err != nil || next.String() != tt.next {
+			t.Errorf("%s.Next() = %q, %v, want %q", tt.id, next, err, tt.next)
+		}
+		prev, err := mustParse(t, tt.next).Prev()
+		if err != nil || prev.String() != tt.id {
+			t.Errorf("%s.Prev() = %q, %v, want %q", tt.next, prev, err, tt.id)
+		}
+	}
+
+	prev, err := mustParse(t, "tmp.1").Prev()
+	if err != nil || prev.String() != "tmp.0" {
+		t.Errorf("tmp.1.Prev() = %q, %v, want tmp.0", prev, err)
+	}
+	for _, first := range []string{"tmp.0", "tmp.4a"} {
+		if prev, err := mustParse(t, first).Prev(); err == nil {
+			t.Errorf("%s.Prev() = %q, want error", first, prev)
+		}
+	}
+}
+
+func TestZettelIDChild(t *testing.T) {
+	tests := []struct {
+		id, seg, want string
+	}{
+		{"tmp.4", "a", "tmp.4a"},
+		{"tmp.4a", "1", "tmp.4a1"},
+		{"tmp.4a1", "za", "tmp.4a1za"},
+	}
+	for _, tt := range tests {
+		child, err := mustParse(t, tt.id).Child(tt.seg)
+		if err != nil || child.String() != tt.want {
+			t.Errorf("%s.Child(%q) = %q, %v, want %q", tt.id, tt.seg, child, err, tt.want)
+		}
+	}
+	for _, tt := range []struct{ id, seg string }{
+		{"tmp.4", ""},
+		{"tmp.4", "1"}, // would make tmp.41
+		{"tmp.4a", "b"},
+		{"tmp.4", "A"},
+	} {
+		if child, err := mustParse(t, tt.id).Child(tt.seg); err == nil {
+			t.Errorf("%s.Child(%q) = %q, want error", tt.id, tt.seg, child)
+		}
+	}
+}
+
+func TestZettelIDCompare(t *testing.T) {
+	// NOTE: in folgezettel order
+	ordered := []string{
+		"j1",
+		"j2",
+		"j10",
+		"j9.1",
+		"j10.1",
+		"tmp.1",
+		"tmp.4",
+		"tmp.4a",
+		"tmp.4a1",
+		"tmp.4a1a1",
+		"tmp.4a2",
+		"tmp.4b1",
+		"tmp.4z1",
+		"tmp.4za1",
+		"tmp.5",
+		"tmp.10",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, b := mustParse(t, ordered[i]), mustParse(t, ordered[j])
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := a.Compare(b); got != want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestZettelIDIsAncestorOf(t *testing.T) {
+	tests := []struct {
+		id, other string
+		want      bool
+	}{
+		{"tmp.4", "tmp.4a1", true},
+		{"tmp.4", "tmp.4a1b2", true},
+		{"tmp.4a", "tmp.4a1", true},
+		{"tmp.4", "tmp.4", false},
+		{"tmp.4", "tmp.5", false},
+		{"tmp.4", "tmp.45", false},
+		{"tmp.4a1", "tmp.4", false},
+		{"tmp.4a", "tmp.4b1", false},
+		{"j1", "j1.1", false},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.id).IsAncestorOf(mustParse(t, tt.other)); got != tt.want {
+			t.Errorf("%s.IsAncestorOf(%s) = %v, want %v", tt.id, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestZettelIDHasPrefix(t *testing.T) {
+	tests := []struct {
+		id, prefix string
+		want       bool
+	}{
+		{"j1.4", "j1", true},
+		{"j1.1.2", "j1", true},
+		{"j1.1.2", "j1.1", true},
+		{"j10.1", "j1", false},
+		{"j1a1", "j1", false},
+		{"j1a1", "j", true},
+		{"tmp.4a1", "tmp", true},
+		{"tmp.4a1", "tm", false},
+		{"tmp.4a1", "tmp.4", false},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.id).HasPrefix(tt.prefix); got != tt.want {
+			t.Errorf("%s.HasPrefix(%q) = %v, want %v", tt.id, tt.prefix, got, tt.want)
+		}
+	}
+}